@@ -0,0 +1,226 @@
+// Package proto implements a small streaming RESP (REdis Serialization
+// Protocol) reader/writer, modeled after go-redis's internal/proto, so the
+// server can decode pipelined commands and encode replies without
+// allocating or flushing per command.
+package proto
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// RESP type prefixes.
+const (
+	RespStatus = '+'
+	RespError  = '-'
+	RespInt    = ':'
+	RespString = '$'
+	RespArray  = '*'
+)
+
+// ErrNil is returned when a bulk string or array reply is RESP nil
+// ($-1 or *-1).
+var ErrNil = errors.New("proto: nil reply")
+
+// Reader decodes RESP values off a buffered stream. It is not safe for
+// concurrent use.
+type Reader struct {
+	rd *bufio.Reader
+}
+
+// NewReader wraps rd in a Reader.
+func NewReader(rd *bufio.Reader) *Reader {
+	return &Reader{rd: rd}
+}
+
+// Buffered returns the number of bytes already read into the underlying
+// buffer that have not yet been consumed, i.e. how much of the next
+// command (if any) is available without blocking on the network.
+func (r *Reader) Buffered() int {
+	return r.rd.Buffered()
+}
+
+// ReadLine reads a single CRLF-terminated line, excluding the CRLF. Lines
+// longer than the underlying bufio.Reader's buffer are reassembled.
+func (r *Reader) ReadLine() ([]byte, error) {
+	line, err := r.rd.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		full := append([]byte(nil), line...)
+		for err == bufio.ErrBufferFull {
+			line, err = r.rd.ReadSlice('\n')
+			full = append(full, line...)
+		}
+		line = full
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("proto: invalid line ending %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+// ReadReply reads one complete RESP value, dispatching on its leading
+// type byte. Inline commands (a bare line with no leading type byte) are
+// returned as a []string of space-separated fields, matching the
+// real-world RESP allowance for inline requests.
+func (r *Reader) ReadReply() (interface{}, error) {
+	line, err := r.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return []string{}, nil
+	}
+	switch line[0] {
+	case RespStatus:
+		return string(line[1:]), nil
+	case RespError:
+		return nil, errors.New(string(line[1:]))
+	case RespInt:
+		return parseInt(line[1:])
+	case RespString:
+		return r.readBulkBody(line)
+	case RespArray:
+		return r.readArrayBody(line)
+	default:
+		return splitFields(line), nil
+	}
+}
+
+// ReadInt reads an integer (`:`) reply.
+func (r *Reader) ReadInt() (int64, error) {
+	line, err := r.ReadLine()
+	if err != nil {
+		return 0, err
+	}
+	if len(line) == 0 {
+		return 0, fmt.Errorf("proto: empty reply where int expected")
+	}
+	switch line[0] {
+	case RespInt:
+		return parseInt(line[1:])
+	case RespError:
+		return 0, errors.New(string(line[1:]))
+	default:
+		return 0, fmt.Errorf("proto: unexpected reply type %q for int", line[0])
+	}
+}
+
+// ReadString reads a bulk string (`$`) or status (`+`) reply. It returns
+// ErrNil for a RESP nil bulk string ($-1).
+func (r *Reader) ReadString() (string, error) {
+	line, err := r.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if len(line) == 0 {
+		return "", fmt.Errorf("proto: empty reply where string expected")
+	}
+	switch line[0] {
+	case RespString:
+		return r.readBulkBody(line)
+	case RespStatus:
+		return string(line[1:]), nil
+	case RespError:
+		return "", errors.New(string(line[1:]))
+	default:
+		return "", fmt.Errorf("proto: unexpected reply type %q for string", line[0])
+	}
+}
+
+// ReadArrayLen reads an array (`*`) header and returns its element count.
+// A length of -1 denotes a RESP nil array.
+func (r *Reader) ReadArrayLen() (int, error) {
+	line, err := r.ReadLine()
+	if err != nil {
+		return 0, err
+	}
+	if len(line) == 0 || line[0] != RespArray {
+		return 0, fmt.Errorf("proto: expected array, got %q", line)
+	}
+	return strconv.Atoi(string(line[1:]))
+}
+
+// ReadN reads exactly n raw bytes followed by the trailing CRLF, as used
+// for a bulk string's payload, and returns the n bytes without the CRLF.
+func (r *Reader) ReadN(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, ErrNil
+	}
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(r.rd, buf); err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+func (r *Reader) readBulkBody(line []byte) (string, error) {
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil {
+		return "", fmt.Errorf("proto: invalid bulk length %q", line)
+	}
+	if n < 0 {
+		return "", ErrNil
+	}
+	b, err := r.ReadN(n)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (r *Reader) readArrayBody(line []byte) ([]interface{}, error) {
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil {
+		return nil, fmt.Errorf("proto: invalid array length %q", line)
+	}
+	if n < 0 {
+		return nil, ErrNil
+	}
+	vals := make([]interface{}, n)
+	for i := 0; i < n; i++ {
+		v, err := r.ReadReply()
+		if err != nil {
+			if err == ErrNil {
+				vals[i] = nil
+				continue
+			}
+			return nil, err
+		}
+		vals[i] = v
+	}
+	return vals, nil
+}
+
+func parseInt(b []byte) (int64, error) {
+	return strconv.ParseInt(string(b), 10, 64)
+}
+
+// splitFields splits an inline command line (no leading RESP type byte)
+// on runs of spaces, the allowance the real protocol makes for clients
+// that don't want to frame a multi-bulk array.
+func splitFields(line []byte) []string {
+	var fields []string
+	start := -1
+	for i, c := range line {
+		if c == ' ' {
+			if start >= 0 {
+				fields = append(fields, string(line[start:i]))
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		fields = append(fields, string(line[start:]))
+	}
+	return fields
+}
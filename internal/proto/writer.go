@@ -0,0 +1,125 @@
+package proto
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Writer encodes RESP values onto an io.Writer, reusing a single scratch
+// buffer across calls so framing a reply never allocates. Callers must
+// call Flush to push buffered bytes to the wire; Writer does not flush on
+// its own, so a caller can batch several replies into one syscall.
+type Writer struct {
+	w   io.Writer
+	buf []byte
+}
+
+// NewWriter wraps w in a Writer. w is typically a *bufio.Writer so Flush
+// controls exactly when bytes hit the socket.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, buf: make([]byte, 0, 64)}
+}
+
+// Flush flushes the underlying writer if it has a Flush method (e.g.
+// *bufio.Writer). Every Write* call already hands its framed bytes to the
+// underlying writer, so for an unbuffered one Flush does nothing.
+func (w *Writer) Flush() error {
+	if f, ok := w.w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// WriteStatus writes a simple status (`+`) reply.
+func (w *Writer) WriteStatus(s string) error {
+	w.buf = append(w.buf[:0], '+')
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, '\r', '\n')
+	return w.flushBuf()
+}
+
+// WriteError writes an error (`-`) reply.
+func (w *Writer) WriteError(s string) error {
+	w.buf = append(w.buf[:0], '-')
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, '\r', '\n')
+	return w.flushBuf()
+}
+
+// WriteInt writes an integer (`:`) reply.
+func (w *Writer) WriteInt(n int64) error {
+	w.buf = append(w.buf[:0], ':')
+	w.buf = strconv.AppendInt(w.buf, n, 10)
+	w.buf = append(w.buf, '\r', '\n')
+	return w.flushBuf()
+}
+
+// WriteNil writes a RESP nil bulk string ($-1).
+func (w *Writer) WriteNil() error {
+	w.buf = append(w.buf[:0], '$', '-', '1', '\r', '\n')
+	return w.flushBuf()
+}
+
+// WriteNilArray writes a RESP nil array (*-1).
+func (w *Writer) WriteNilArray() error {
+	w.buf = append(w.buf[:0], '*', '-', '1', '\r', '\n')
+	return w.flushBuf()
+}
+
+// WriteBulkString writes s as a `$len\r\n...\r\n` bulk string.
+func (w *Writer) WriteBulkString(s string) error {
+	w.buf = append(w.buf[:0], '$')
+	w.buf = strconv.AppendInt(w.buf, int64(len(s)), 10)
+	w.buf = append(w.buf, '\r', '\n')
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, '\r', '\n')
+	return w.flushBuf()
+}
+
+// WriteArrayHeader writes a `*n\r\n` array header for a reply of n
+// elements, each written with a subsequent call such as WriteArg.
+func (w *Writer) WriteArrayHeader(n int) error {
+	w.buf = append(w.buf[:0], '*')
+	w.buf = strconv.AppendInt(w.buf, int64(n), 10)
+	w.buf = append(w.buf, '\r', '\n')
+	return w.flushBuf()
+}
+
+// WriteArg writes v as a single bulk-string argument, the framing used
+// both for commands sent to a peer and for bulk elements of an array
+// reply. nil is written as a RESP nil bulk string.
+func (w *Writer) WriteArg(v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		return w.WriteNil()
+	case string:
+		return w.WriteBulkString(v)
+	case []byte:
+		return w.writeBulkBytes(v)
+	case int:
+		return w.writeBulkInt(int64(v))
+	case int64:
+		return w.writeBulkInt(v)
+	default:
+		return fmt.Errorf("proto: unsupported arg type %T", v)
+	}
+}
+
+func (w *Writer) writeBulkBytes(b []byte) error {
+	w.buf = append(w.buf[:0], '$')
+	w.buf = strconv.AppendInt(w.buf, int64(len(b)), 10)
+	w.buf = append(w.buf, '\r', '\n')
+	w.buf = append(w.buf, b...)
+	w.buf = append(w.buf, '\r', '\n')
+	return w.flushBuf()
+}
+
+func (w *Writer) writeBulkInt(n int64) error {
+	return w.WriteBulkString(strconv.FormatInt(n, 10))
+}
+
+func (w *Writer) flushBuf() error {
+	_, err := w.w.Write(w.buf)
+	return err
+}
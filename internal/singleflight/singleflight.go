@@ -0,0 +1,52 @@
+// Package singleflight provides a duplicate-call suppression mechanism:
+// concurrent callers asking for the same key collapse into a single
+// underlying call, the classic fix for a thundering herd of cache misses
+// on the same hot key.
+package singleflight
+
+import "sync"
+
+// call is an in-flight or completed Do call for one key.
+type call struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+// Group coalesces concurrent Do calls for the same key. The zero value
+// is ready to use.
+type Group struct {
+	mu sync.Mutex
+	m  map[string]*call
+}
+
+// Do executes and returns the results of fn, making sure only one
+// execution is in flight for a given key at a time. If a duplicate call
+// comes in while one is still running, the duplicate waits for the
+// original to complete and receives the same results; shared reports
+// whether this call's result came from such a duplicate.
+func (g *Group) Do(key string, fn func() (interface{}, error)) (v interface{}, shared bool, err error) {
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	if c, ok := g.m[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, true, c.err
+	}
+
+	c := new(call)
+	c.wg.Add(1)
+	g.m[key] = c
+	g.mu.Unlock()
+
+	c.val, c.err = fn()
+	c.wg.Done()
+
+	g.mu.Lock()
+	delete(g.m, key)
+	g.mu.Unlock()
+
+	return c.val, false, c.err
+}
@@ -13,3 +13,35 @@ func FNV32(s string) uint32 {
 	}
 	return h
 }
+
+// HashSlot hashes a key for shard/node placement, honoring Redis
+// Cluster's hash-tag convention: if key contains a `{tag}` substring with
+// a non-empty tag, only the tag is hashed, so callers can force related
+// keys (e.g. "user:{42}:profile" and "user:{42}:sessions") onto the same
+// shard or node. Otherwise the whole key is hashed.
+func HashSlot(key string) uint32 {
+	if tag, ok := hashTag(key); ok {
+		return FNV32(tag)
+	}
+	return FNV32(key)
+}
+
+// hashTag extracts the X out of the first "{X}" substring in key, if any,
+// requiring X to be non-empty per the Redis Cluster hash-tag rule.
+func hashTag(key string) (string, bool) {
+	start := -1
+	for i := 0; i < len(key); i++ {
+		switch key[i] {
+		case '{':
+			if start < 0 {
+				start = i
+			}
+		case '}':
+			if start >= 0 && i > start+1 {
+				return key[start+1 : i], true
+			}
+			start = -1
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,32 @@
+package utils
+
+import "testing"
+
+func TestHashSlotTaggedKeysCollide(t *testing.T) {
+	tagged := []string{
+		"user:{42}:profile",
+		"user:{42}:sessions",
+		"{42}",
+		"other-prefix:{42}:suffix",
+	}
+
+	want := HashSlot(tagged[0])
+	for _, key := range tagged[1:] {
+		if got := HashSlot(key); got != want {
+			t.Errorf("HashSlot(%q) = %d, want %d (same tag as %q)", key, got, want, tagged[0])
+		}
+	}
+}
+
+func TestHashSlotWithoutTagHashesWholeKey(t *testing.T) {
+	if HashSlot("user:1:profile") == HashSlot("user:2:profile") {
+		t.Fatal("expected untagged keys to hash differently")
+	}
+}
+
+func TestHashSlotEmptyTagFallsBackToWholeKey(t *testing.T) {
+	key := "user:{}:profile"
+	if HashSlot(key) != FNV32(key) {
+		t.Errorf("HashSlot(%q) should fall back to hashing the whole key for an empty tag", key)
+	}
+}
@@ -0,0 +1,18 @@
+package cache
+
+import "time"
+
+// Cache is the command surface pkg/server.Server dispatches onto. A
+// *ShardedCache satisfies it directly; cluster.ClusterCache satisfies it
+// too, by serving keys this node owns locally and proxying the rest to
+// their owning peer, so Server can run unmodified in either mode.
+type Cache interface {
+	Get(key string) (string, bool)
+	Put(key, value string) error
+	MGet(keys []string) []GetResult
+	MSet(pairs []KV) error
+	DelMany(keys []string) int
+	ExistsMany(keys []string) int
+	Expire(key string, ttl time.Duration) bool
+	TTL(key string) (remaining time.Duration, hasExpiry, exists bool)
+}
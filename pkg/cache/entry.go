@@ -1,8 +1,12 @@
 package cache
 
+import "time"
+
+// Entry is one cached key/value pair, plus an optional expiry.
 type Entry struct {
-	key   string
-	value string
+	key              string
+	value            string
+	expireAtUnixNano int64 // 0 means no expiry
 }
 
 func (e *Entry) Key() string {
@@ -12,3 +16,8 @@ func (e *Entry) Key() string {
 func (e *Entry) Value() string {
 	return e.value
 }
+
+// Expired reports whether e has a TTL set and it has elapsed as of now.
+func (e *Entry) Expired(now time.Time) bool {
+	return e.expireAtUnixNano != 0 && now.UnixNano() >= e.expireAtUnixNano
+}
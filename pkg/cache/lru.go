@@ -3,6 +3,9 @@ package cache
 import (
 	"container/list"
 	"sync"
+	"time"
+
+	"server/internal/singleflight"
 )
 
 // single shard of the cache
@@ -11,6 +14,11 @@ type LRUCache struct {
 	items    map[string]*list.Element
 	list     *list.List
 	mutex    sync.RWMutex
+
+	// loads coalesces concurrent loader calls for the same missing key
+	// within this shard so a hot-key miss triggers one origin fetch
+	// instead of one per waiter.
+	loads singleflight.Group
 }
 
 // inits a new LRU cache with the given capacity
@@ -21,3 +29,184 @@ func NewLRUCache(capacity int) *LRUCache {
 		list:     list.New(),
 	}
 }
+
+// lookup returns the current value for key if it is present and has not
+// expired. If the entry is present but its TTL has elapsed, found is
+// false and expired is true so the caller can evict it (done outside
+// the read lock held here).
+func (s *LRUCache) lookup(key string) (value string, found, expired bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.lookupLocked(key, time.Now())
+}
+
+// lookupLocked is lookup without acquiring s.mutex, for callers that
+// already hold it (at least for reading) themselves — e.g. a multi-key
+// call locking several shards at once.
+func (s *LRUCache) lookupLocked(key string, now time.Time) (value string, found, expired bool) {
+	elem, ok := s.items[key]
+	if !ok {
+		return "", false, false
+	}
+	entry, ok := elem.Value.(*Entry)
+	if !ok || entry == nil {
+		return "", false, false
+	}
+	if entry.Expired(now) {
+		return "", false, true
+	}
+	s.list.MoveToFront(elem)
+	return entry.value, true, false
+}
+
+// evict removes key if it is still present, used after lookup reports
+// it has expired.
+func (s *LRUCache) evict(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.delLocked(key)
+}
+
+// del removes key unconditionally and reports whether it was present.
+func (s *LRUCache) del(key string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.delLocked(key)
+}
+
+// existsLocked reports whether key is present, ignoring TTL, for a
+// caller that already holds s.mutex and needs to know before it acts —
+// e.g. whether a removal needs a persisted tombstone at all.
+func (s *LRUCache) existsLocked(key string) bool {
+	_, ok := s.items[key]
+	return ok
+}
+
+// delLocked is del without acquiring s.mutex, for callers that already
+// hold it themselves.
+func (s *LRUCache) delLocked(key string) bool {
+	elem, ok := s.items[key]
+	if !ok {
+		return false
+	}
+	delete(s.items, key)
+	s.list.Remove(elem)
+	return true
+}
+
+// putLocked inserts or updates key/value/expireAtUnixNano, evicting the
+// shard's oldest entry if it's now over capacity. Callers must already
+// hold s.mutex for writing — e.g. a multi-key call locking several
+// shards at once, or putLocalAbs locking this one shard on its own.
+func (s *LRUCache) putLocked(key, value string, expireAtUnixNano int64) {
+	if elem, ok := s.items[key]; ok {
+		s.list.MoveToFront(elem)
+		if entry, ok := elem.Value.(*Entry); ok && entry != nil {
+			entry.value = value
+			entry.expireAtUnixNano = expireAtUnixNano
+		}
+		return
+	}
+
+	elem := s.list.PushFront(&Entry{key: key, value: value, expireAtUnixNano: expireAtUnixNano})
+	s.items[key] = elem
+
+	if s.list.Len() > s.capacity {
+		if oldest := s.list.Back(); oldest != nil {
+			if entry, ok := oldest.Value.(*Entry); ok && entry != nil {
+				delete(s.items, entry.key)
+				s.list.Remove(oldest)
+			}
+		}
+	}
+}
+
+// setExpireAt sets key's expiry to expireAtUnixNano (0 clears it) and
+// reports whether key was present to update. A key whose previous TTL
+// has already elapsed, but hasn't been swept yet, is evicted instead of
+// resurrected, matching Redis's EXPIRE semantics for an already-gone key.
+func (s *LRUCache) setExpireAt(key string, expireAtUnixNano int64) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	elem, ok := s.items[key]
+	if !ok {
+		return false
+	}
+	entry, ok := elem.Value.(*Entry)
+	if !ok || entry == nil {
+		return false
+	}
+	if entry.Expired(time.Now()) {
+		delete(s.items, key)
+		s.list.Remove(elem)
+		return false
+	}
+	entry.expireAtUnixNano = expireAtUnixNano
+	return true
+}
+
+// ttl reports key's remaining time-to-live. exists is false if key is
+// absent or has already expired; hasExpiry is false if key exists with
+// no TTL set.
+func (s *LRUCache) ttl(key string) (remaining time.Duration, hasExpiry, exists bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	elem, ok := s.items[key]
+	if !ok {
+		return 0, false, false
+	}
+	entry, ok := elem.Value.(*Entry)
+	if !ok || entry == nil {
+		return 0, false, false
+	}
+	if entry.expireAtUnixNano == 0 {
+		return 0, false, true
+	}
+	remaining = time.Until(time.Unix(0, entry.expireAtUnixNano))
+	if remaining < 0 {
+		return 0, false, false
+	}
+	return remaining, true, true
+}
+
+// runSweeper periodically evicts a sample of expired keys so that
+// keys which are never looked up again (and so never hit the lazy
+// expiry check in lookup) still get reclaimed. It returns once stop is
+// closed.
+func (s *LRUCache) runSweeper(stop <-chan struct{}) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.sweepExpired(sweepSampleSize)
+		}
+	}
+}
+
+// sweepExpired samples up to sampleSize keys and evicts any that have
+// expired. Go's randomized map iteration order makes a plain range over
+// items a cheap stand-in for Redis's random-sampling active expiration,
+// without needing a separate index of keys by expiry.
+func (s *LRUCache) sweepExpired(sampleSize int) (sampled, removed int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for key, elem := range s.items {
+		if sampled >= sampleSize {
+			break
+		}
+		sampled++
+		if entry, ok := elem.Value.(*Entry); ok && entry != nil && entry.Expired(now) {
+			delete(s.items, key)
+			s.list.Remove(elem)
+			removed++
+		}
+	}
+	return sampled, removed
+}
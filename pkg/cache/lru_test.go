@@ -0,0 +1,18 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpireDoesNotResurrectExpiredKey(t *testing.T) {
+	s := NewLRUCache(16)
+	s.items["k"] = s.list.PushFront(&Entry{key: "k", value: "v", expireAtUnixNano: time.Now().Add(-time.Second).UnixNano()})
+
+	if ok := s.setExpireAt("k", time.Now().Add(time.Minute).UnixNano()); ok {
+		t.Fatal("setExpireAt resurrected an already-expired key")
+	}
+	if _, found, _ := s.lookup("k"); found {
+		t.Fatal("expired key is still present after setExpireAt")
+	}
+}
@@ -1,66 +1,505 @@
 package cache
 
 import (
+	"context"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
 	"server/internal/utils"
+	"server/pkg/persist"
 )
 
 const (
 	NumShards = 16
+
+	// durabilityPollInterval is how often the durability loop wakes up
+	// to check whether the AOL has grown past PersistConfig's
+	// CompactThreshold; SnapshotInterval, when set, is a multiple of it.
+	durabilityPollInterval = time.Second
+
+	// sweepInterval and sweepSampleSize control the background active
+	// expiration sweeper: how often each shard samples for expired keys,
+	// and how many keys it samples per pass.
+	sweepInterval   = 100 * time.Millisecond
+	sweepSampleSize = 20
 )
 
+// Loader fetches the value for a cache-missed key from the origin.
+type Loader func(ctx context.Context, key string) (value string, ttl time.Duration, err error)
+
+// loaderStats are the coalesced-load counters exposed via LoaderStats.
+type loaderStats struct {
+	loads     int64 // loader invocations that actually ran
+	coalesced int64 // Get calls that joined an in-flight load instead
+	latencyNs int64 // cumulative wall time spent inside loader calls
+}
+
 // manages multiple cache shards for better concurrency
 type ShardedCache struct {
-	shards [NumShards]*LRUCache
+	shards    [NumShards]*LRUCache
+	loader    Loader
+	stats     loaderStats
+	stopSweep chan struct{}
+
+	persistCfg     persist.Config
+	store          *persist.Store
+	stopDurability chan struct{}
 }
 
-func NewShardedCache(capacityPerShard int) *ShardedCache {
-	sc := &ShardedCache{}
+// NewShardedCache builds a ShardedCache. If persistCfg is non-nil, the
+// cache becomes restart-safe: on startup it replays the latest snapshot
+// plus the AOL tail written since to rebuild its shards, and a
+// background goroutine keeps snapshotting (and compacting the AOL) per
+// persistCfg thereafter. Pass nil for a pure in-memory cache.
+func NewShardedCache(capacityPerShard int, persistCfg *persist.Config) (*ShardedCache, error) {
+	sc := &ShardedCache{stopSweep: make(chan struct{})}
 	for i := 0; i < NumShards; i++ {
 		sc.shards[i] = NewLRUCache(capacityPerShard)
 	}
-	return sc
+	for _, shard := range sc.shards {
+		go shard.runSweeper(sc.stopSweep)
+	}
+
+	if persistCfg == nil {
+		return sc, nil
+	}
+
+	store, err := persist.Open(*persistCfg)
+	if err != nil {
+		close(sc.stopSweep)
+		return nil, fmt.Errorf("cache: open persistence: %w", err)
+	}
+	if err := store.Replay(func(key, value string, expireAtUnixNano int64, deleted bool) {
+		if deleted {
+			sc.getShard(key).del(key)
+			return
+		}
+		sc.putLocalAbs(key, value, expireAtUnixNano)
+	}); err != nil {
+		store.Close()
+		close(sc.stopSweep)
+		return nil, fmt.Errorf("cache: replay persisted state: %w", err)
+	}
+
+	sc.persistCfg = *persistCfg
+	sc.store = store
+	sc.stopDurability = make(chan struct{})
+	go sc.runDurability()
+	return sc, nil
+}
+
+// Close stops the background sweeper and durability goroutines (if any)
+// and releases any on-disk files. Safe to call on a cache built without
+// a PersistConfig.
+func (sc *ShardedCache) Close() error {
+	close(sc.stopSweep)
+	if sc.store == nil {
+		return nil
+	}
+	close(sc.stopDurability)
+	return sc.store.Close()
+}
+
+// runDurability periodically snapshots the cache to disk: on every tick
+// of durabilityPollInterval it checks whether the configured
+// SnapshotInterval has elapsed or the AOL has grown past
+// CompactThreshold, and if so takes a snapshot (which also truncates the
+// AOL, since a fresh snapshot already captures everything in it).
+func (sc *ShardedCache) runDurability() {
+	ticker := time.NewTicker(durabilityPollInterval)
+	defer ticker.Stop()
+
+	var sinceSnapshot time.Duration
+	for {
+		select {
+		case <-sc.stopDurability:
+			return
+		case <-ticker.C:
+			sinceSnapshot += durabilityPollInterval
+			due := sc.persistCfg.SnapshotInterval > 0 && sinceSnapshot >= sc.persistCfg.SnapshotInterval
+			if due || sc.store.ShouldCompact() {
+				sinceSnapshot = 0
+				sc.snapshotAll()
+			}
+		}
+	}
+}
+
+// snapshotAll walks every shard under its own read lock and hands the
+// resulting key/value/expireAtUnixNano triples to the persistence Store.
+// Entries that have already logically expired but haven't been swept yet
+// are left out, so a restart never resurrects a dead key.
+func (sc *ShardedCache) snapshotAll() error {
+	now := time.Now()
+	return sc.store.Snapshot(func(yield func(key, value string, expireAtUnixNano int64)) {
+		for _, shard := range sc.shards {
+			shard.mutex.RLock()
+			for e := shard.list.Front(); e != nil; e = e.Next() {
+				if entry, ok := e.Value.(*Entry); ok && entry != nil && !entry.Expired(now) {
+					yield(entry.key, entry.value, entry.expireAtUnixNano)
+				}
+			}
+			shard.mutex.RUnlock()
+		}
+	})
+}
+
+// SetLoader installs an origin-loader hook: when Get misses, the owning
+// shard coalesces concurrent misses for the same key behind a
+// singleflight.Group so only one call to loader runs per hot-key miss,
+// turning this into a usable read-through cache in front of a slow
+// backend.
+func (sc *ShardedCache) SetLoader(loader Loader) {
+	sc.loader = loader
 }
 
+// LoaderStats reports how many loader calls actually ran, how many Get
+// calls instead rode along on an in-flight call for the same key, and
+// the average latency of a loader call.
+func (sc *ShardedCache) LoaderStats() (loads, coalesced int64, avgLatency time.Duration) {
+	loads = atomic.LoadInt64(&sc.stats.loads)
+	coalesced = atomic.LoadInt64(&sc.stats.coalesced)
+	if loads == 0 {
+		return loads, coalesced, 0
+	}
+	return loads, coalesced, time.Duration(atomic.LoadInt64(&sc.stats.latencyNs) / loads)
+}
+
+// shardIndex picks the index of the shard owning key, honoring
+// hash-tags so related keys (see utils.HashSlot) land on the same
+// shard.
+func (sc *ShardedCache) shardIndex(key string) int {
+	return int(utils.HashSlot(key) & (NumShards - 1))
+}
+
+// getShard picks the shard owning key.
 func (sc *ShardedCache) getShard(key string) *LRUCache {
-	return sc.shards[utils.FNV32(key)&(NumShards-1)]
+	return sc.shards[sc.shardIndex(key)]
+}
+
+// lockShards locks, in ascending shard-index order, every distinct
+// shard touched by keys. Always locking in the same order — regardless
+// of the order keys themselves happen to arrive in — is what lets two
+// concurrent multi-key calls that touch overlapping shards never
+// deadlock waiting on each other. write selects Lock vs RLock. It
+// returns the locked shard indices in ascending order, plus an unlock
+// func the caller must invoke exactly once.
+func (sc *ShardedCache) lockShards(keys []string, write bool) (shardIdx []int, unlock func()) {
+	touched := make(map[int]struct{}, len(keys))
+	for _, key := range keys {
+		touched[sc.shardIndex(key)] = struct{}{}
+	}
+	shardIdx = make([]int, 0, len(touched))
+	for idx := range touched {
+		shardIdx = append(shardIdx, idx)
+	}
+	sort.Ints(shardIdx)
+
+	for _, idx := range shardIdx {
+		if write {
+			sc.shards[idx].mutex.Lock()
+		} else {
+			sc.shards[idx].mutex.RLock()
+		}
+	}
+	return shardIdx, func() {
+		for i := len(shardIdx) - 1; i >= 0; i-- {
+			if write {
+				sc.shards[shardIdx[i]].mutex.Unlock()
+			} else {
+				sc.shards[shardIdx[i]].mutex.RUnlock()
+			}
+		}
+	}
 }
 
 func (sc *ShardedCache) Get(key string) (string, bool) {
 	shard := sc.getShard(key)
-	shard.mutex.RLock()
-	defer shard.mutex.RUnlock()
 
-	if elem, ok := shard.items[key]; ok {
-		shard.list.MoveToFront(elem)
-		if entry, ok := elem.Value.(*Entry); ok && entry != nil {
-			return entry.value, true
+	value, found, expired := shard.lookup(key)
+	if found {
+		return value, true
+	}
+	if expired {
+		shard.evict(key)
+	}
+
+	if sc.loader == nil {
+		return "", false
+	}
+	return sc.loadMiss(shard, key)
+}
+
+// MGet looks up every key as one atomic operation: it locks every shard
+// the keys touch (in a fixed order — see lockShards) before reading any
+// of them, so a concurrent MSET can never be observed half-applied
+// across this batch. Keys whose TTL has elapsed are evicted after the
+// shard locks are released.
+func (sc *ShardedCache) MGet(keys []string) []GetResult {
+	_, unlock := sc.lockShards(keys, false)
+
+	results := make([]GetResult, len(keys))
+	var expiredKeys []string
+	now := time.Now()
+	for i, key := range keys {
+		value, found, expired := sc.getShard(key).lookupLocked(key, now)
+		results[i] = GetResult{Value: value, Found: found}
+		if expired {
+			expiredKeys = append(expiredKeys, key)
+		}
+	}
+	unlock()
+
+	for _, key := range expiredKeys {
+		sc.getShard(key).evict(key)
+	}
+	return results
+}
+
+// GetResult is one key's outcome from MGet.
+type GetResult struct {
+	Value string
+	Found bool
+}
+
+// loadMiss runs sc.loader for key through the shard's singleflight
+// group, so concurrent misses for the same key collapse into one
+// origin call; the result is inserted into the LRU for every waiter.
+func (sc *ShardedCache) loadMiss(shard *LRUCache, key string) (string, bool) {
+	start := time.Now()
+	v, shared, err := shard.loads.Do(key, func() (interface{}, error) {
+		value, ttl, err := sc.loader(context.Background(), key)
+		if err != nil {
+			return "", err
+		}
+		if putErr := sc.putWithTTL(key, value, ttl); putErr != nil {
+			return "", putErr
+		}
+		return value, nil
+	})
+
+	if shared {
+		atomic.AddInt64(&sc.stats.coalesced, 1)
+	} else {
+		atomic.AddInt64(&sc.stats.loads, 1)
+		atomic.AddInt64(&sc.stats.latencyNs, int64(time.Since(start)))
+	}
+	if err != nil {
+		return "", false
+	}
+	return v.(string), true
+}
+
+// Put stores key/value with no expiry. If the cache was built with a
+// PersistConfig, the write is durably appended to the on-disk log before
+// Put returns, so a caller can safely acknowledge the write to its
+// client only after Put succeeds.
+func (sc *ShardedCache) Put(key, value string) error {
+	return sc.putWithTTL(key, value, 0)
+}
+
+// MSet stores every pair as one atomic operation: it locks every shard
+// the pairs touch (in a fixed order — see lockShards) before applying
+// any of them, so a concurrent GET/MGET can never observe the batch
+// half-applied. If persistence is configured and a write fails partway
+// through, the pairs already appended keep whatever they got; MSet
+// returns the first error.
+func (sc *ShardedCache) MSet(pairs []KV) error {
+	if sc.store != nil {
+		sc.store.WriteLock()
+		defer sc.store.WriteUnlock()
+	}
+
+	keys := make([]string, len(pairs))
+	for i, p := range pairs {
+		keys[i] = p.Key
+	}
+	_, unlock := sc.lockShards(keys, true)
+	defer unlock()
+
+	for _, p := range pairs {
+		if sc.store != nil {
+			if err := sc.store.AppendPut(p.Key, p.Value, 0); err != nil {
+				return fmt.Errorf("cache: persist put: %w", err)
+			}
 		}
+		sc.getShard(p.Key).putLocked(p.Key, p.Value, 0)
 	}
-	return "", false
+	return nil
 }
 
-func (sc *ShardedCache) Put(key, value string) {
+// KV is one key/value pair for MSet.
+type KV struct {
+	Key   string
+	Value string
+}
+
+// putWithTTL appends key/value to the AOL (if persistence is
+// configured) and applies it to the in-memory shard as one unit with
+// respect to a concurrent snapshot: it holds the store's write lock for
+// both steps, so Snapshot can never observe the AOL write without also
+// observing its in-memory effect (which would otherwise let it capture
+// the old value and then truncate away the only record of the new one).
+func (sc *ShardedCache) putWithTTL(key, value string, ttl time.Duration) error {
+	var expireAt int64
+	if ttl > 0 {
+		expireAt = time.Now().Add(ttl).UnixNano()
+	}
+
+	if sc.store == nil {
+		sc.putLocalAbs(key, value, expireAt)
+		return nil
+	}
+
+	sc.store.WriteLock()
+	defer sc.store.WriteUnlock()
+
+	if err := sc.store.AppendPut(key, value, expireAt); err != nil {
+		return fmt.Errorf("cache: persist put: %w", err)
+	}
+	sc.putLocalAbs(key, value, expireAt)
+	return nil
+}
+
+// putLocalAbs applies key/value/expireAtUnixNano to the in-memory shard
+// only, used by Put, loader-backed fills, and startup replay
+// (which must not re-append what it just replayed). A key that is
+// already expired as of expireAtUnixNano is evicted instead of inserted,
+// so replaying a snapshot/AOL tail past a key's expiry can't resurrect
+// it.
+func (sc *ShardedCache) putLocalAbs(key, value string, expireAtUnixNano int64) {
 	shard := sc.getShard(key)
 	shard.mutex.Lock()
 	defer shard.mutex.Unlock()
 
-	if elem, ok := shard.items[key]; ok {
-		shard.list.MoveToFront(elem)
-		if entry, ok := elem.Value.(*Entry); ok && entry != nil {
-			entry.value = value
-		}
+	if expireAtUnixNano != 0 && expireAtUnixNano <= time.Now().UnixNano() {
+		shard.delLocked(key)
 		return
 	}
+	shard.putLocked(key, value, expireAtUnixNano)
+}
 
-	elem := shard.list.PushFront(&Entry{key: key, value: value})
-	shard.items[key] = elem
+// Del removes key and reports whether it was present. If the cache was
+// built with a PersistConfig, the removal is durably recorded as an AOL
+// tombstone before Del returns, the same way putWithTTL's write is, so a
+// deleted key can't come back from a stale snapshot after a restart.
+func (sc *ShardedCache) Del(key string) bool {
+	return sc.DelMany([]string{key}) > 0
+}
+
+// DelMany removes every key as one atomic operation — every shard the
+// keys touch is locked (in a fixed order — see lockShards) before any
+// of them is removed — and returns how many were actually present. If
+// persistence is configured, it holds the store's write lock for the
+// whole batch, so Snapshot can never land between a key's tombstone and
+// its in-memory removal; a key whose tombstone fails to persist is left
+// untouched in memory rather than silently dropped.
+func (sc *ShardedCache) DelMany(keys []string) int {
+	if sc.store != nil {
+		sc.store.WriteLock()
+		defer sc.store.WriteUnlock()
+	}
 
-	if shard.list.Len() > shard.capacity {
-		if oldest := shard.list.Back(); oldest != nil {
-			if entry, ok := oldest.Value.(*Entry); ok && entry != nil {
-				delete(shard.items, entry.key)
-				shard.list.Remove(oldest)
+	_, unlock := sc.lockShards(keys, true)
+	defer unlock()
+
+	n := 0
+	for _, key := range keys {
+		shard := sc.getShard(key)
+		if !shard.existsLocked(key) {
+			continue
+		}
+		if sc.store != nil {
+			if err := sc.store.AppendDel(key); err != nil {
+				continue
 			}
 		}
+		shard.delLocked(key)
+		n++
+	}
+	return n
+}
+
+// Exists reports whether key is present and not expired.
+func (sc *ShardedCache) Exists(key string) bool {
+	shard := sc.getShard(key)
+	_, found, expired := shard.lookup(key)
+	if expired {
+		shard.evict(key)
 	}
+	return found
+}
+
+// ExistsMany reports, as one atomic operation, how many of keys are
+// present and not expired — every shard the keys touch is locked (in a
+// fixed order — see lockShards) before any of them is checked. Keys
+// whose TTL has elapsed are evicted after the shard locks are released.
+func (sc *ShardedCache) ExistsMany(keys []string) int {
+	_, unlock := sc.lockShards(keys, false)
+
+	n := 0
+	var expiredKeys []string
+	now := time.Now()
+	for _, key := range keys {
+		_, found, expired := sc.getShard(key).lookupLocked(key, now)
+		if found {
+			n++
+		} else if expired {
+			expiredKeys = append(expiredKeys, key)
+		}
+	}
+	unlock()
+
+	for _, key := range expiredKeys {
+		sc.getShard(key).evict(key)
+	}
+	return n
+}
+
+// Expire sets key's time-to-live to ttl from now, reporting whether key
+// was present to update. If the cache was built with a PersistConfig,
+// the new expiry is durably recorded before Expire returns, by
+// re-appending the key's current value under the new expiry — the same
+// way any other write reaches the AOL. The shard lock is held across the
+// whole lookup-append-apply sequence (instead of being released between
+// the lookup and the apply) so a concurrent DelMany can't remove key
+// from memory in between and have this call's AppendPut log a value for
+// it anyway, which would resurrect it on the next restart.
+func (sc *ShardedCache) Expire(key string, ttl time.Duration) bool {
+	shard := sc.getShard(key)
+
+	if sc.store == nil {
+		expireAt := time.Now().Add(ttl).UnixNano()
+		return shard.setExpireAt(key, expireAt)
+	}
+
+	sc.store.WriteLock()
+	defer sc.store.WriteUnlock()
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	value, found, expired := shard.lookupLocked(key, time.Now())
+	if expired {
+		shard.delLocked(key)
+	}
+	if !found {
+		return false
+	}
+
+	expireAt := time.Now().Add(ttl).UnixNano()
+	if err := sc.store.AppendPut(key, value, expireAt); err != nil {
+		return false
+	}
+	shard.putLocked(key, value, expireAt)
+	return true
+}
+
+// TTL reports key's remaining time-to-live. exists is false if key is
+// absent or has already expired; hasExpiry is false if key exists with
+// no TTL set.
+func (sc *ShardedCache) TTL(key string) (remaining time.Duration, hasExpiry, exists bool) {
+	return sc.getShard(key).ttl(key)
 }
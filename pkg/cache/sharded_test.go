@@ -0,0 +1,223 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"server/pkg/persist"
+)
+
+func TestGetShardHashTagCoLocation(t *testing.T) {
+	sc, err := NewShardedCache(16, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := sc.getShard("user:{42}:profile")
+	for _, key := range []string{"user:{42}:sessions", "{42}", "x:{42}:y"} {
+		if got := sc.getShard(key); got != want {
+			t.Errorf("getShard(%q) landed on a different shard than the {42} tag group", key)
+		}
+	}
+}
+
+// TestMSetAtomicAcrossShards picks two keys that land on different
+// shards and hammers MSet on both of them concurrently with MGet: since
+// MSet locks every touched shard before applying any pair, MGet must
+// never observe the two keys holding values from different writes.
+func TestMSetAtomicAcrossShards(t *testing.T) {
+	sc, err := NewShardedCache(16, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var k1, k2 string
+	for i := 0; ; i++ {
+		a, b := fmt.Sprintf("a%d", i), fmt.Sprintf("b%d", i)
+		if sc.shardIndex(a) != sc.shardIndex(b) {
+			k1, k2 = a, b
+			break
+		}
+	}
+
+	if err := sc.MSet([]KV{{Key: k1, Value: "v0"}, {Key: k2, Value: "v0"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	const iterations = 2000
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < iterations; i++ {
+			v := fmt.Sprintf("v%d", i)
+			if err := sc.MSet([]KV{{Key: k1, Value: v}, {Key: k2, Value: v}}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+			res := sc.MGet([]string{k1, k2})
+			if res[0].Value != res[1].Value {
+				t.Fatalf("observed partially-applied MSet: %s=%q %s=%q", k1, res[0].Value, k2, res[1].Value)
+			}
+		}
+	}
+}
+
+// TestTTLSurvivesRestart checks that a key's expiry, not just its value,
+// is durable: after a restart the key must still report (roughly) the
+// same remaining TTL instead of having become permanent.
+func TestTTLSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	cfg := persist.Config{Dir: dir}
+
+	sc, err := NewShardedCache(16, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.putWithTTL("k1", "v1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sc2, err := NewShardedCache(16, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc2.Close()
+
+	remaining, hasExpiry, exists := sc2.TTL("k1")
+	if !exists || !hasExpiry {
+		t.Fatalf("TTL(k1) after restart: exists=%v hasExpiry=%v, want true/true", exists, hasExpiry)
+	}
+	if remaining <= 0 || remaining > time.Hour {
+		t.Fatalf("TTL(k1) after restart = %v, want roughly 1h", remaining)
+	}
+}
+
+// TestExpiredKeyNotResurrectedAfterRestart checks that a key whose TTL
+// elapsed before a restart (and so was never swept) does not come back
+// as permanent once durability replays the snapshot/AOL.
+func TestExpiredKeyNotResurrectedAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	cfg := persist.Config{Dir: dir}
+
+	sc, err := NewShardedCache(16, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.putWithTTL("k1", "v1", time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if err := sc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sc2, err := NewShardedCache(16, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc2.Close()
+
+	if _, found := sc2.Get("k1"); found {
+		t.Fatal("expired key was resurrected by replay")
+	}
+}
+
+// TestDeletedKeyNotResurrectedAfterRestart checks the DEL analog of
+// TestExpiredKeyNotResurrectedAfterRestart: a key removed with DelMany
+// before a restart must not come back just because an earlier snapshot
+// or AOL record still held its last value.
+func TestDeletedKeyNotResurrectedAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	cfg := persist.Config{Dir: dir}
+
+	sc, err := NewShardedCache(16, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.Put("foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	if n := sc.DelMany([]string{"foo"}); n != 1 {
+		t.Fatalf("DelMany([foo]) = %d, want 1", n)
+	}
+	if _, found := sc.Get("foo"); found {
+		t.Fatal("foo still found right after DelMany")
+	}
+	if err := sc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sc2, err := NewShardedCache(16, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc2.Close()
+
+	if _, found := sc2.Get("foo"); found {
+		t.Fatal("deleted key was resurrected by replay")
+	}
+}
+
+// TestExpireRacingDelManyNeverResurrectsAfterRestart hammers Expire and
+// DelMany on the same persisted key concurrently, then restarts the
+// cache: since Expire now holds the shard lock across its whole
+// lookup-append-apply sequence, a DelMany landing mid-Expire must either
+// happen entirely before or entirely after it, never in between — so the
+// key's final AOL state always agrees with whether DelMany's last run
+// removed it, and the key can never come back from a stale AppendPut.
+func TestExpireRacingDelManyNeverResurrectsAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	cfg := persist.Config{Dir: dir}
+
+	sc, err := NewShardedCache(16, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.Put("k1", "v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	const iterations = 500
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < iterations; i++ {
+			sc.Expire("k1", time.Hour)
+		}
+	}()
+	for i := 0; i < iterations; i++ {
+		sc.DelMany([]string{"k1"})
+		sc.Put("k1", "v1")
+	}
+	<-done
+	sc.DelMany([]string{"k1"})
+
+	if _, found := sc.Get("k1"); found {
+		t.Fatal("k1 still found right after the final DelMany")
+	}
+	if err := sc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sc2, err := NewShardedCache(16, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc2.Close()
+
+	if _, found := sc2.Get("k1"); found {
+		t.Fatal("k1 was resurrected by replay after a restart")
+	}
+}
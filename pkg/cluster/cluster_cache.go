@@ -0,0 +1,305 @@
+package cluster
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"server/internal/proto"
+	"server/pkg/cache"
+)
+
+// ClusterCache fronts a local ShardedCache with a consistent-hash Ring
+// so several server instances can act as one logical cache: a request
+// for a key owned by this node is served from the local shards, and a
+// request for a key owned by a peer is proxied to it over a pooled RESP
+// connection.
+type ClusterCache struct {
+	self  string
+	ring  *Ring
+	local *cache.ShardedCache
+	pool  *peerPool
+}
+
+// ClusterCache satisfies cache.Cache, so pkg/server.Server can dispatch
+// onto it exactly as it would a bare *cache.ShardedCache.
+var _ cache.Cache = (*ClusterCache)(nil)
+
+// NewClusterCache builds a ClusterCache for the node listening at self
+// (its "host:port", used both as the node's ring identity and as the
+// address peers dial to reach it), seeded with the static list of peer
+// addresses in seeds (self should be included if it should also own a
+// portion of the ring).
+func NewClusterCache(self string, seeds []string, local *cache.ShardedCache) *ClusterCache {
+	r := NewRing(DefaultReplicas)
+	r.Add(seeds...)
+	return &ClusterCache{
+		self:  self,
+		ring:  r,
+		local: local,
+		pool:  newPeerPool(),
+	}
+}
+
+// Rebalance updates cluster membership to exactly nodes, letting
+// operators add/remove peers at runtime without restarting this node.
+func (c *ClusterCache) Rebalance(nodes []string) {
+	c.ring.Rebalance(nodes)
+}
+
+// Get returns the value for key, serving it from the local shards if
+// this node owns it, or proxying to the owning peer otherwise.
+func (c *ClusterCache) Get(key string) (string, bool) {
+	owner := c.ring.Get(key)
+	if owner == "" || owner == c.self {
+		return c.local.Get(key)
+	}
+	return c.getRemote(owner, key)
+}
+
+// Put stores key/value, locally if this node owns the key, or by
+// proxying the write to the owning peer otherwise.
+func (c *ClusterCache) Put(key, value string) error {
+	owner := c.ring.Get(key)
+	if owner == "" || owner == c.self {
+		return c.local.Put(key, value)
+	}
+	return c.putRemote(owner, key, value)
+}
+
+func (c *ClusterCache) getRemote(addr, key string) (string, bool) {
+	pc, err := c.pool.get(addr)
+	if err != nil {
+		return "", false
+	}
+
+	pc.wr.WriteArrayHeader(2)
+	pc.wr.WriteArg("GET")
+	pc.wr.WriteArg(key)
+	if err := pc.wr.Flush(); err != nil {
+		c.pool.drop(pc)
+		return "", false
+	}
+
+	val, err := pc.rd.ReadString()
+	if err != nil {
+		if err == proto.ErrNil {
+			c.pool.put(addr, pc)
+			return "", false
+		}
+		c.pool.drop(pc)
+		return "", false
+	}
+	c.pool.put(addr, pc)
+	return val, true
+}
+
+// MGet looks up every key, serving the ones this node owns from the
+// local shards and proxying the rest to their owning peer one at a time,
+// preserving keys' input order in the result.
+func (c *ClusterCache) MGet(keys []string) []cache.GetResult {
+	results := make([]cache.GetResult, len(keys))
+	for i, key := range keys {
+		value, found := c.Get(key)
+		results[i] = cache.GetResult{Value: value, Found: found}
+	}
+	return results
+}
+
+// MSet stores every pair, locally for the ones this node owns and by
+// proxying the rest to their owning peer one at a time. It returns the
+// first error, the same way ShardedCache.MSet does.
+func (c *ClusterCache) MSet(pairs []cache.KV) error {
+	for _, p := range pairs {
+		if err := c.Put(p.Key, p.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DelMany removes every key, locally for the ones this node owns and by
+// proxying the rest to their owning peer, returning how many were
+// actually present.
+func (c *ClusterCache) DelMany(keys []string) int {
+	n := 0
+	for _, key := range keys {
+		if c.del(key) {
+			n++
+		}
+	}
+	return n
+}
+
+// ExistsMany reports how many of keys are present and not expired,
+// locally for the ones this node owns and by proxying the rest to their
+// owning peer.
+func (c *ClusterCache) ExistsMany(keys []string) int {
+	n := 0
+	for _, key := range keys {
+		if c.exists(key) {
+			n++
+		}
+	}
+	return n
+}
+
+// Expire sets key's time-to-live to ttl from now, locally if this node
+// owns key, or by proxying to the owning peer otherwise.
+func (c *ClusterCache) Expire(key string, ttl time.Duration) bool {
+	owner := c.ring.Get(key)
+	if owner == "" || owner == c.self {
+		return c.local.Expire(key, ttl)
+	}
+	return c.expireRemote(owner, key, ttl)
+}
+
+// TTL reports key's remaining time-to-live, locally if this node owns
+// key, or by proxying to the owning peer otherwise.
+func (c *ClusterCache) TTL(key string) (remaining time.Duration, hasExpiry, exists bool) {
+	owner := c.ring.Get(key)
+	if owner == "" || owner == c.self {
+		return c.local.TTL(key)
+	}
+	return c.ttlRemote(owner, key)
+}
+
+func (c *ClusterCache) del(key string) bool {
+	owner := c.ring.Get(key)
+	if owner == "" || owner == c.self {
+		return c.local.DelMany([]string{key}) > 0
+	}
+	return c.delRemote(owner, key)
+}
+
+func (c *ClusterCache) exists(key string) bool {
+	owner := c.ring.Get(key)
+	if owner == "" || owner == c.self {
+		return c.local.ExistsMany([]string{key}) > 0
+	}
+	return c.existsRemote(owner, key)
+}
+
+func (c *ClusterCache) delRemote(addr, key string) bool {
+	pc, err := c.pool.get(addr)
+	if err != nil {
+		return false
+	}
+
+	pc.wr.WriteArrayHeader(2)
+	pc.wr.WriteArg("DEL")
+	pc.wr.WriteArg(key)
+	if err := pc.wr.Flush(); err != nil {
+		c.pool.drop(pc)
+		return false
+	}
+
+	n, err := pc.rd.ReadInt()
+	if err != nil {
+		c.pool.drop(pc)
+		return false
+	}
+	c.pool.put(addr, pc)
+	return n > 0
+}
+
+func (c *ClusterCache) existsRemote(addr, key string) bool {
+	pc, err := c.pool.get(addr)
+	if err != nil {
+		return false
+	}
+
+	pc.wr.WriteArrayHeader(2)
+	pc.wr.WriteArg("EXISTS")
+	pc.wr.WriteArg(key)
+	if err := pc.wr.Flush(); err != nil {
+		c.pool.drop(pc)
+		return false
+	}
+
+	n, err := pc.rd.ReadInt()
+	if err != nil {
+		c.pool.drop(pc)
+		return false
+	}
+	c.pool.put(addr, pc)
+	return n > 0
+}
+
+func (c *ClusterCache) expireRemote(addr, key string, ttl time.Duration) bool {
+	pc, err := c.pool.get(addr)
+	if err != nil {
+		return false
+	}
+
+	pc.wr.WriteArrayHeader(3)
+	pc.wr.WriteArg("EXPIRE")
+	pc.wr.WriteArg(key)
+	pc.wr.WriteArg(strconv.FormatInt(int64(ttl/time.Second), 10))
+	if err := pc.wr.Flush(); err != nil {
+		c.pool.drop(pc)
+		return false
+	}
+
+	n, err := pc.rd.ReadInt()
+	if err != nil {
+		c.pool.drop(pc)
+		return false
+	}
+	c.pool.put(addr, pc)
+	return n > 0
+}
+
+func (c *ClusterCache) ttlRemote(addr, key string) (remaining time.Duration, hasExpiry, exists bool) {
+	pc, err := c.pool.get(addr)
+	if err != nil {
+		return 0, false, false
+	}
+
+	pc.wr.WriteArrayHeader(2)
+	pc.wr.WriteArg("TTL")
+	pc.wr.WriteArg(key)
+	if err := pc.wr.Flush(); err != nil {
+		c.pool.drop(pc)
+		return 0, false, false
+	}
+
+	n, err := pc.rd.ReadInt()
+	if err != nil {
+		c.pool.drop(pc)
+		return 0, false, false
+	}
+	c.pool.put(addr, pc)
+
+	switch {
+	case n == -2:
+		return 0, false, false
+	case n == -1:
+		return 0, false, true
+	default:
+		return time.Duration(n) * time.Second, true, true
+	}
+}
+
+func (c *ClusterCache) putRemote(addr, key, value string) error {
+	pc, err := c.pool.get(addr)
+	if err != nil {
+		return fmt.Errorf("cluster: dial %s: %w", addr, err)
+	}
+
+	pc.wr.WriteArrayHeader(3)
+	pc.wr.WriteArg("PUT")
+	pc.wr.WriteArg(key)
+	pc.wr.WriteArg(value)
+	if err := pc.wr.Flush(); err != nil {
+		c.pool.drop(pc)
+		return fmt.Errorf("cluster: write to %s: %w", addr, err)
+	}
+
+	if _, err := pc.rd.ReadString(); err != nil {
+		c.pool.drop(pc)
+		return fmt.Errorf("cluster: reply from %s: %w", addr, err)
+	}
+	c.pool.put(addr, pc)
+	return nil
+}
@@ -0,0 +1,325 @@
+package cluster
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"server/internal/proto"
+	"server/pkg/cache"
+)
+
+// startFakePeer runs a minimal RESP server speaking just enough of the
+// GET/PUT/DEL/EXISTS/EXPIRE/TTL protocol for ClusterCache's peerConn to
+// talk to, so tests can
+// exercise the proxying path (and peerPool's connection reuse) over a
+// real socket without needing a full pkg/server.Server.
+func startFakePeer(t *testing.T) string {
+	t.Helper()
+	var mu sync.Mutex
+	values := make(map[string]string)
+	expireAt := make(map[string]time.Time) // absent key = no expiry
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				rd := proto.NewReader(bufio.NewReader(conn))
+				wr := proto.NewWriter(bufio.NewWriter(conn))
+				for {
+					reply, err := rd.ReadReply()
+					if err != nil {
+						return
+					}
+					fields, ok := reply.([]interface{})
+					if !ok || len(fields) == 0 {
+						return
+					}
+					name, _ := fields[0].(string)
+					switch strings.ToUpper(name) {
+					case "GET":
+						key, _ := fields[1].(string)
+						mu.Lock()
+						value, found := values[key]
+						mu.Unlock()
+						if found {
+							wr.WriteBulkString(value)
+						} else {
+							wr.WriteNil()
+						}
+					case "PUT":
+						key, _ := fields[1].(string)
+						value, _ := fields[2].(string)
+						mu.Lock()
+						values[key] = value
+						delete(expireAt, key)
+						mu.Unlock()
+						wr.WriteStatus("OK")
+					case "DEL":
+						key, _ := fields[1].(string)
+						mu.Lock()
+						_, found := values[key]
+						delete(values, key)
+						delete(expireAt, key)
+						mu.Unlock()
+						if found {
+							wr.WriteInt(1)
+						} else {
+							wr.WriteInt(0)
+						}
+					case "EXISTS":
+						key, _ := fields[1].(string)
+						mu.Lock()
+						_, found := values[key]
+						mu.Unlock()
+						if found {
+							wr.WriteInt(1)
+						} else {
+							wr.WriteInt(0)
+						}
+					case "EXPIRE":
+						key, _ := fields[1].(string)
+						seconds, _ := fields[2].(string)
+						n, _ := strconv.Atoi(seconds)
+						mu.Lock()
+						_, found := values[key]
+						if found {
+							expireAt[key] = time.Now().Add(time.Duration(n) * time.Second)
+						}
+						mu.Unlock()
+						if found {
+							wr.WriteInt(1)
+						} else {
+							wr.WriteInt(0)
+						}
+					case "TTL":
+						key, _ := fields[1].(string)
+						mu.Lock()
+						_, found := values[key]
+						at, hasExpiry := expireAt[key]
+						mu.Unlock()
+						switch {
+						case !found:
+							wr.WriteInt(-2)
+						case !hasExpiry:
+							wr.WriteInt(-1)
+						default:
+							wr.WriteInt(int64(time.Until(at) / time.Second))
+						}
+					default:
+						wr.WriteError("ERR unknown command")
+					}
+					wr.Flush()
+				}
+			}(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return ln.Addr().String()
+}
+
+// findKeyRoutedTo samples keys until it finds one the ring routes to
+// owner, so a test can exercise the remote-proxy path deterministically.
+func findKeyRoutedTo(t *testing.T, cc *ClusterCache, owner, suffix string) string {
+	t.Helper()
+	for i := 0; i < 10000; i++ {
+		k := randKey(i) + suffix
+		if cc.ring.Get(k) == owner {
+			return k
+		}
+	}
+	t.Fatalf("no sampled key routed to %q", owner)
+	return ""
+}
+
+// TestClusterCacheServesLocalKeyLocally checks that a key this node owns
+// never goes over the wire to a peer.
+func TestClusterCacheServesLocalKeyLocally(t *testing.T) {
+	local, err := cache.NewShardedCache(64, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	self := "127.0.0.1:0"
+	cc := NewClusterCache(self, []string{self}, local)
+
+	if err := cc.Put("k1", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if v, found := local.Get("k1"); !found || v != "v1" {
+		t.Fatalf("local.Get(k1) = %q,%v, want v1,true", v, found)
+	}
+	if v, found := cc.Get("k1"); !found || v != "v1" {
+		t.Fatalf("cc.Get(k1) = %q,%v, want v1,true", v, found)
+	}
+}
+
+// TestClusterCacheProxiesToOwningPeer checks that a key owned by a peer
+// is written/read through a real proxied RESP round trip rather than
+// being served from this node's local shards.
+func TestClusterCacheProxiesToOwningPeer(t *testing.T) {
+	peerAddr := startFakePeer(t)
+
+	local, err := cache.NewShardedCache(64, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	self := "127.0.0.1:1"
+	cc := NewClusterCache(self, []string{self, peerAddr}, local)
+
+	key := findKeyRoutedTo(t, cc, peerAddr, "")
+
+	if err := cc.Put(key, "remote-value"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := local.Get(key); found {
+		t.Fatal("key routed to the peer was written to the local shard instead")
+	}
+
+	got, found := cc.Get(key)
+	if !found || got != "remote-value" {
+		t.Fatalf("cc.Get(%q) = %q,%v, want remote-value,true", key, got, found)
+	}
+}
+
+// TestClusterCacheGetMissingRemoteKey checks that a cache miss on the
+// owning peer comes back as not-found rather than an error.
+func TestClusterCacheGetMissingRemoteKey(t *testing.T) {
+	peerAddr := startFakePeer(t)
+
+	local, err := cache.NewShardedCache(64, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	self := "127.0.0.1:1"
+	cc := NewClusterCache(self, []string{self, peerAddr}, local)
+
+	key := findKeyRoutedTo(t, cc, peerAddr, "-miss")
+
+	if _, found := cc.Get(key); found {
+		t.Fatalf("cc.Get(%q) on an unset key reported found", key)
+	}
+}
+
+// TestClusterCacheDelExistsExpireTTLProxyToOwningPeer checks that
+// DelMany, ExistsMany, Expire, and TTL all route a peer-owned key over
+// the wire instead of silently falling back to the local shard, the same
+// way Get/Put already do.
+func TestClusterCacheDelExistsExpireTTLProxyToOwningPeer(t *testing.T) {
+	peerAddr := startFakePeer(t)
+
+	local, err := cache.NewShardedCache(64, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	self := "127.0.0.1:1"
+	cc := NewClusterCache(self, []string{self, peerAddr}, local)
+
+	key := findKeyRoutedTo(t, cc, peerAddr, "")
+	if err := cc.Put(key, "v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := cc.ExistsMany([]string{key}); n != 1 {
+		t.Fatalf("ExistsMany([%q]) = %d, want 1", key, n)
+	}
+
+	if !cc.Expire(key, time.Hour) {
+		t.Fatalf("Expire(%q) = false, want true", key)
+	}
+	remaining, hasExpiry, exists := cc.TTL(key)
+	if !exists || !hasExpiry || remaining <= 0 || remaining > time.Hour {
+		t.Fatalf("TTL(%q) = %v,%v,%v, want roughly 1h,true,true", key, remaining, hasExpiry, exists)
+	}
+
+	if n := cc.DelMany([]string{key}); n != 1 {
+		t.Fatalf("DelMany([%q]) = %d, want 1", key, n)
+	}
+	if n := cc.ExistsMany([]string{key}); n != 0 {
+		t.Fatalf("ExistsMany([%q]) after DelMany = %d, want 0", key, n)
+	}
+	if _, _, exists := cc.TTL(key); exists {
+		t.Fatalf("TTL(%q) after DelMany reports exists=true", key)
+	}
+}
+
+// TestClusterCacheRebalanceChangesOwnership checks that Rebalance on a
+// ClusterCache (not just the underlying Ring) actually takes effect: a
+// key initially routed to a peer that Rebalance then drops must be
+// served locally afterward instead of still being proxied to it.
+func TestClusterCacheRebalanceChangesOwnership(t *testing.T) {
+	peerAddr := startFakePeer(t)
+
+	local, err := cache.NewShardedCache(64, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	self := "127.0.0.1:1"
+	cc := NewClusterCache(self, []string{self, peerAddr}, local)
+
+	key := findKeyRoutedTo(t, cc, peerAddr, "")
+	if err := cc.Put(key, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := local.Get(key); found {
+		t.Fatal("key routed to the peer was written to the local shard before Rebalance")
+	}
+
+	cc.Rebalance([]string{self})
+
+	if owner := cc.ring.Get(key); owner != self {
+		t.Fatalf("ring.Get(%q) after Rebalance = %q, want %q", key, owner, self)
+	}
+	if err := cc.Put(key, "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if v, found := local.Get(key); !found || v != "v2" {
+		t.Fatalf("local.Get(%q) after Rebalance = %q,%v, want v2,true", key, v, found)
+	}
+}
+
+// TestClusterCacheMSetSplitsAcrossLocalAndRemote checks that MSet applies
+// each pair to wherever the ring routes its key, local or remote, and
+// MGet reads them back correctly regardless of which.
+func TestClusterCacheMSetSplitsAcrossLocalAndRemote(t *testing.T) {
+	peerAddr := startFakePeer(t)
+
+	local, err := cache.NewShardedCache(64, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	self := "127.0.0.1:1"
+	cc := NewClusterCache(self, []string{self, peerAddr}, local)
+
+	localKey := findKeyRoutedTo(t, cc, self, "-local")
+	remoteKey := findKeyRoutedTo(t, cc, peerAddr, "-remote")
+
+	if err := cc.MSet([]cache.KV{{Key: localKey, Value: "lv"}, {Key: remoteKey, Value: "rv"}}); err != nil {
+		t.Fatal(err)
+	}
+	if v, found := local.Get(localKey); !found || v != "lv" {
+		t.Fatalf("local.Get(%q) = %q,%v, want lv,true", localKey, v, found)
+	}
+	if _, found := local.Get(remoteKey); found {
+		t.Fatalf("remote-owned key %q landed in the local shard", remoteKey)
+	}
+
+	results := cc.MGet([]string{localKey, remoteKey})
+	if !results[0].Found || results[0].Value != "lv" {
+		t.Fatalf("MGet local result = %+v, want lv/found", results[0])
+	}
+	if !results[1].Found || results[1].Value != "rv" {
+		t.Fatalf("MGet remote result = %+v, want rv/found", results[1])
+	}
+}
@@ -0,0 +1,70 @@
+package cluster
+
+import (
+	"bufio"
+	"net"
+	"sync"
+
+	"server/internal/proto"
+)
+
+// peerConn is a connection to a peer node, framed with the same proto
+// reader/writer the server itself uses.
+type peerConn struct {
+	conn net.Conn
+	rd   *proto.Reader
+	wr   *proto.Writer
+}
+
+// peerPool is a small per-peer-address connection pool so proxied GET/PUT
+// calls reuse TCP connections instead of dialing one per request.
+type peerPool struct {
+	mu   sync.Mutex
+	idle map[string][]*peerConn
+	dial func(addr string) (net.Conn, error)
+}
+
+func newPeerPool() *peerPool {
+	return &peerPool{
+		idle: make(map[string][]*peerConn),
+		dial: func(addr string) (net.Conn, error) {
+			return net.Dial("tcp", addr)
+		},
+	}
+}
+
+// get returns an idle connection to addr, dialing a new one if none is
+// pooled.
+func (p *peerPool) get(addr string) (*peerConn, error) {
+	p.mu.Lock()
+	if conns := p.idle[addr]; len(conns) > 0 {
+		pc := conns[len(conns)-1]
+		p.idle[addr] = conns[:len(conns)-1]
+		p.mu.Unlock()
+		return pc, nil
+	}
+	p.mu.Unlock()
+
+	conn, err := p.dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &peerConn{
+		conn: conn,
+		rd:   proto.NewReader(bufio.NewReader(conn)),
+		wr:   proto.NewWriter(bufio.NewWriter(conn)),
+	}, nil
+}
+
+// put returns a still-healthy connection to the pool for reuse.
+func (p *peerPool) put(addr string, pc *peerConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.idle[addr] = append(p.idle[addr], pc)
+}
+
+// drop closes a connection that errored instead of returning it to the
+// pool.
+func (p *peerPool) drop(pc *peerConn) {
+	pc.conn.Close()
+}
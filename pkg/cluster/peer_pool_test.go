@@ -0,0 +1,74 @@
+package cluster
+
+import (
+	"net"
+	"testing"
+)
+
+// TestPeerPoolReusesPutConnection checks that a connection returned via
+// put is handed back out by a later get instead of a fresh dial.
+func TestPeerPoolReusesPutConnection(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	p := newPeerPool()
+	addr := ln.Addr().String()
+
+	pc1, err := p.get(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.put(addr, pc1)
+
+	pc2, err := p.get(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pc2 != pc1 {
+		t.Fatal("get after put dialed a new connection instead of reusing the idle one")
+	}
+}
+
+// TestPeerPoolDropDoesNotReturnToIdle checks that a dropped connection
+// never comes back out of get.
+func TestPeerPoolDropDoesNotReturnToIdle(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	p := newPeerPool()
+	addr := ln.Addr().String()
+
+	pc, err := p.get(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.drop(pc)
+
+	if idle := p.idle[addr]; len(idle) != 0 {
+		t.Fatalf("idle pool for %s after drop = %v, want empty", addr, idle)
+	}
+}
@@ -0,0 +1,150 @@
+// Package cluster lets several instances of this server form a
+// distributed cache using consistent hashing, in the style of go-redis's
+// internal/consistenthash: each node owns an arc of a hash ring, and a
+// key is routed to whichever node's virtual nodes it falls after.
+package cluster
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+
+	"server/internal/utils"
+)
+
+// DefaultReplicas is the number of virtual nodes placed on the ring per
+// real node when none is configured, chosen to keep the ring evenly
+// distributed even with a handful of real nodes.
+const DefaultReplicas = 100
+
+// HashFunc hashes a string to a ring position.
+type HashFunc func(string) uint32
+
+// Ring is a consistent-hash ring mapping keys to node names. The zero
+// value is not usable; construct with NewRing. A Ring is safe for
+// concurrent use.
+type Ring struct {
+	mu       sync.RWMutex
+	replicas int
+	hash     HashFunc
+	keys     []uint32          // sorted virtual node hashes
+	hashMap  map[uint32]string // virtual node hash -> real node name
+}
+
+// NewRing builds an empty Ring with the given number of virtual nodes
+// per real node. replicas <= 0 selects DefaultReplicas.
+func NewRing(replicas int) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	return &Ring{
+		replicas: replicas,
+		hash:     utils.FNV32,
+		hashMap:  make(map[uint32]string),
+	}
+}
+
+// SetHash overrides the hash function used to place virtual nodes.
+// Nodes already on the ring keep the positions the previous function
+// gave them, so call it before Add. Keys passed to Get are always hashed
+// with utils.HashSlot, whatever function is set here.
+func (r *Ring) SetHash(h HashFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hash = h
+}
+
+// Add inserts replicas virtual nodes per real node into the ring.
+func (r *Ring) Add(nodes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, node := range nodes {
+		for i := 0; i < r.replicas; i++ {
+			h := r.hash(strconv.Itoa(i) + node)
+			r.keys = append(r.keys, h)
+			r.hashMap[h] = node
+		}
+	}
+	sort.Slice(r.keys, func(i, j int) bool { return r.keys[i] < r.keys[j] })
+}
+
+// Remove deletes every virtual node belonging to node from the ring.
+func (r *Ring) Remove(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	filtered := r.keys[:0]
+	for _, h := range r.keys {
+		if r.hashMap[h] == node {
+			delete(r.hashMap, h)
+			continue
+		}
+		filtered = append(filtered, h)
+	}
+	r.keys = filtered
+}
+
+// Get returns the node that owns key: the first virtual node whose hash
+// is >= hash(key), wrapping around to index 0. Returns "" if the ring is
+// empty. Keys are hashed with utils.HashSlot, the same hash-tag-aware
+// function ShardedCache uses, so a tagged key always maps to the same
+// node as it does to a local shard.
+func (r *Ring) Get(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.keys) == 0 {
+		return ""
+	}
+	h := utils.HashSlot(key)
+	idx := sort.Search(len(r.keys), func(i int) bool { return r.keys[i] >= h })
+	if idx == len(r.keys) {
+		idx = 0
+	}
+	return r.hashMap[r.keys[idx]]
+}
+
+// Nodes returns the distinct real node names currently on the ring.
+func (r *Ring) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	seen := make(map[string]struct{})
+	nodes := make([]string, 0, len(seen))
+	for _, h := range r.keys {
+		name := r.hashMap[h]
+		if _, ok := seen[name]; !ok {
+			seen[name] = struct{}{}
+			nodes = append(nodes, name)
+		}
+	}
+	return nodes
+}
+
+// Rebalance replaces the ring's membership with exactly nodes, so
+// operators can add/remove cluster nodes at runtime without restarting
+// any process: nodes missing from the ring are added, nodes no longer
+// listed are removed.
+func (r *Ring) Rebalance(nodes []string) {
+	want := make(map[string]struct{}, len(nodes))
+	for _, n := range nodes {
+		want[n] = struct{}{}
+	}
+
+	for _, existing := range r.Nodes() {
+		if _, ok := want[existing]; !ok {
+			r.Remove(existing)
+		}
+	}
+
+	var toAdd []string
+	have := make(map[string]struct{})
+	for _, n := range r.Nodes() {
+		have[n] = struct{}{}
+	}
+	for _, n := range nodes {
+		if _, ok := have[n]; !ok {
+			toAdd = append(toAdd, n)
+		}
+	}
+	if len(toAdd) > 0 {
+		r.Add(toAdd...)
+	}
+}
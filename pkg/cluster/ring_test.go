@@ -0,0 +1,101 @@
+package cluster
+
+import (
+	"testing"
+)
+
+func TestRingGetIsStableAcrossCalls(t *testing.T) {
+	r := NewRing(DefaultReplicas)
+	r.Add("node-a", "node-b", "node-c")
+
+	owner := r.Get("somekey")
+	if owner == "" {
+		t.Fatal("Get on a non-empty ring returned no owner")
+	}
+	for i := 0; i < 100; i++ {
+		if got := r.Get("somekey"); got != owner {
+			t.Fatalf("Get(%q) = %q on call %d, want stable %q", "somekey", got, i, owner)
+		}
+	}
+}
+
+func TestRingGetOnEmptyRing(t *testing.T) {
+	r := NewRing(DefaultReplicas)
+	if got := r.Get("anykey"); got != "" {
+		t.Fatalf("Get on empty ring = %q, want \"\"", got)
+	}
+}
+
+func TestRingDistributesKeysAcrossAllNodes(t *testing.T) {
+	r := NewRing(DefaultReplicas)
+	nodes := []string{"node-a", "node-b", "node-c"}
+	r.Add(nodes...)
+
+	seen := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		owner := r.Get(randKey(i))
+		seen[owner]++
+	}
+	for _, n := range nodes {
+		if seen[n] == 0 {
+			t.Errorf("node %q never owned any of 1000 sampled keys", n)
+		}
+	}
+}
+
+func TestRingRemoveStopsOwningKeys(t *testing.T) {
+	r := NewRing(DefaultReplicas)
+	r.Add("node-a", "node-b")
+	r.Remove("node-a")
+
+	for i := 0; i < 200; i++ {
+		if owner := r.Get(randKey(i)); owner == "node-a" {
+			t.Fatalf("key %q still routed to removed node-a", randKey(i))
+		}
+	}
+	if nodes := r.Nodes(); len(nodes) != 1 || nodes[0] != "node-b" {
+		t.Fatalf("Nodes() after Remove = %v, want [node-b]", nodes)
+	}
+}
+
+func TestRingRebalanceAddsAndRemoves(t *testing.T) {
+	r := NewRing(DefaultReplicas)
+	r.Add("node-a", "node-b")
+
+	r.Rebalance([]string{"node-b", "node-c"})
+
+	got := map[string]bool{}
+	for _, n := range r.Nodes() {
+		got[n] = true
+	}
+	if got["node-a"] {
+		t.Fatal("Rebalance left node-a on the ring")
+	}
+	if !got["node-b"] || !got["node-c"] {
+		t.Fatalf("Rebalance result = %v, want node-b and node-c present", r.Nodes())
+	}
+}
+
+// TestRingHashTagCoLocation checks Ring.Get honors the same hash-tag
+// convention as cache.ShardedCache.getShard, so a tagged key group routes
+// to one node.
+func TestRingHashTagCoLocation(t *testing.T) {
+	r := NewRing(DefaultReplicas)
+	r.Add("node-a", "node-b", "node-c", "node-d")
+
+	want := r.Get("user:{42}:profile")
+	for _, key := range []string{"user:{42}:sessions", "{42}", "x:{42}:y"} {
+		if got := r.Get(key); got != want {
+			t.Errorf("Get(%q) landed on a different node than the {42} tag group", key)
+		}
+	}
+}
+
+func randKey(i int) string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, 8)
+	for j := range b {
+		b[j] = alphabet[(i*31+j*17)%len(alphabet)]
+	}
+	return string(b)
+}
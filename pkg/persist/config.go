@@ -0,0 +1,37 @@
+// Package persist gives ShardedCache optional restart-safe durability:
+// a periodic full snapshot plus an append-only log (AOL) of the
+// mutations since that snapshot, both stored in go.etcd.io/bbolt files.
+package persist
+
+import "time"
+
+// FsyncPolicy trades AOL durability for throughput.
+type FsyncPolicy string
+
+const (
+	// FsyncAlways fsyncs the AOL on every batch of appended writes.
+	FsyncAlways FsyncPolicy = "always"
+	// FsyncEverySec relaxes that to roughly once a second, the same
+	// trade-off Redis's AOF "everysec" policy makes.
+	FsyncEverySec FsyncPolicy = "everysec"
+	// FsyncNever leaves fsyncing to the OS's own writeback, for
+	// deployments that only want crash-consistency from bbolt's
+	// internal transaction ordering, not fsync durability.
+	FsyncNever FsyncPolicy = "no"
+)
+
+// Config configures a Store.
+type Config struct {
+	// Dir holds the snapshot and AOL files.
+	Dir string
+	// SnapshotInterval is how often a full snapshot is taken. Zero
+	// disables time-based snapshotting; a snapshot can still be forced
+	// by AOL growth via CompactThreshold.
+	SnapshotInterval time.Duration
+	// Fsync controls AOL durability vs. throughput.
+	Fsync FsyncPolicy
+	// CompactThreshold forces a snapshot (and the AOL truncation that
+	// comes with it) once the AOL has grown past this many bytes since
+	// the last snapshot. Zero disables size-based compaction.
+	CompactThreshold int64
+}
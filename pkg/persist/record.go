@@ -0,0 +1,98 @@
+package persist
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// recordKind tags an AOL record as carrying a value (recordPut) or
+// marking a key removed since the last snapshot (recordDel), so Replay
+// can tell a delete apart from a write without guessing from its shape.
+type recordKind byte
+
+const (
+	recordPut recordKind = 0
+	recordDel recordKind = 1
+)
+
+// encodeRecord frames a PUT AOL entry as kind(recordPut) ++
+// expireAtUnixNano ++ len(key) ++ key ++ len(value) ++ value, so a single
+// bbolt value can hold the full key/value/expiry triple.
+func encodeRecord(key, value string, expireAtUnixNano int64) []byte {
+	buf := make([]byte, 1+8+4+len(key)+4+len(value))
+	buf[0] = byte(recordPut)
+	binary.BigEndian.PutUint64(buf[1:9], uint64(expireAtUnixNano))
+	binary.BigEndian.PutUint32(buf[9:13], uint32(len(key)))
+	copy(buf[13:], key)
+	off := 13 + len(key)
+	binary.BigEndian.PutUint32(buf[off:off+4], uint32(len(value)))
+	copy(buf[off+4:], value)
+	return buf
+}
+
+// encodeDeleteRecord frames a DEL tombstone as kind(recordDel) ++
+// len(key) ++ key — no value or expiry, since a tombstone only needs to
+// say which key to remove when Replay reaches it.
+func encodeDeleteRecord(key string) []byte {
+	buf := make([]byte, 1+4+len(key))
+	buf[0] = byte(recordDel)
+	binary.BigEndian.PutUint32(buf[1:5], uint32(len(key)))
+	copy(buf[5:], key)
+	return buf
+}
+
+func decodeRecord(b []byte) (kind recordKind, key, value string, expireAtUnixNano int64, err error) {
+	if len(b) < 1 {
+		return 0, "", "", 0, fmt.Errorf("persist: truncated aol record")
+	}
+	kind = recordKind(b[0])
+	b = b[1:]
+
+	if kind == recordDel {
+		if len(b) < 4 {
+			return 0, "", "", 0, fmt.Errorf("persist: truncated aol tombstone")
+		}
+		kl := int(binary.BigEndian.Uint32(b[0:4]))
+		if len(b) < 4+kl {
+			return 0, "", "", 0, fmt.Errorf("persist: truncated aol tombstone")
+		}
+		return recordDel, string(b[4 : 4+kl]), "", 0, nil
+	}
+
+	if len(b) < 12 {
+		return 0, "", "", 0, fmt.Errorf("persist: truncated aol record")
+	}
+	expireAtUnixNano = int64(binary.BigEndian.Uint64(b[0:8]))
+	kl := int(binary.BigEndian.Uint32(b[8:12]))
+	if len(b) < 12+kl+4 {
+		return 0, "", "", 0, fmt.Errorf("persist: truncated aol record")
+	}
+	key = string(b[12 : 12+kl])
+	off := 12 + kl
+	vl := int(binary.BigEndian.Uint32(b[off : off+4]))
+	if len(b) < off+4+vl {
+		return 0, "", "", 0, fmt.Errorf("persist: truncated aol record")
+	}
+	value = string(b[off+4 : off+4+vl])
+	return recordPut, key, value, expireAtUnixNano, nil
+}
+
+// encodeSnapshotValue frames a snapshot bucket value as
+// expireAtUnixNano ++ value; the bucket key is already the cache key,
+// so it isn't repeated here the way it is in an AOL record. A snapshot
+// only ever holds live keys, so it has no tombstone variant.
+func encodeSnapshotValue(value string, expireAtUnixNano int64) []byte {
+	buf := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(buf[0:8], uint64(expireAtUnixNano))
+	copy(buf[8:], value)
+	return buf
+}
+
+func decodeSnapshotValue(b []byte) (value string, expireAtUnixNano int64, err error) {
+	if len(b) < 8 {
+		return "", 0, fmt.Errorf("persist: truncated snapshot value")
+	}
+	expireAtUnixNano = int64(binary.BigEndian.Uint64(b[0:8]))
+	value = string(b[8:])
+	return value, expireAtUnixNano, nil
+}
@@ -0,0 +1,322 @@
+package persist
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	snapshotBucket = []byte("kv")
+	aolBucket      = []byte("aol")
+)
+
+// fsyncEverySecInterval is how often the FsyncEverySec background loop
+// flushes both bbolt files to disk.
+const fsyncEverySecInterval = time.Second
+
+// Store is the on-disk durability layer for a ShardedCache: one bbolt
+// file holds the latest full snapshot, a second holds the append-only
+// log of PUTs and DEL tombstones since that snapshot. A Store is safe for
+// concurrent use.
+//
+// mu also ties a write's AOL append to its in-memory apply: a caller
+// wraps both in WriteLock/WriteUnlock (an RLock), while Snapshot takes
+// mu for writing, so a snapshot can never land between the two halves
+// of a write and capture the old value while also truncating the AOL
+// record that held the new one.
+type Store struct {
+	cfg Config
+
+	mu       sync.RWMutex
+	snapDB   *bolt.DB
+	aolDB    *bolt.DB
+	aolBytes int64
+
+	stopFsync chan struct{}
+}
+
+// WriteLock holds off any concurrent Snapshot for the duration of one
+// write, so a caller can append to the AOL and apply the same write to
+// memory as one atomic unit from Snapshot's point of view. Multiple
+// writers may hold this concurrently; only Snapshot excludes them.
+func (s *Store) WriteLock() { s.mu.RLock() }
+
+// WriteUnlock releases a lock taken by WriteLock.
+func (s *Store) WriteUnlock() { s.mu.RUnlock() }
+
+func snapshotPath(dir string) string { return filepath.Join(dir, "snapshot.db") }
+func aolPath(dir string) string      { return filepath.Join(dir, "aol.db") }
+
+// Open opens (creating if necessary) the snapshot and AOL files under
+// cfg.Dir.
+func Open(cfg Config) (*Store, error) {
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("persist: Config.Dir is required")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("persist: mkdir %s: %w", cfg.Dir, err)
+	}
+
+	opts := &bolt.Options{NoSync: cfg.Fsync != FsyncAlways}
+
+	snapDB, err := bolt.Open(snapshotPath(cfg.Dir), 0o600, opts)
+	if err != nil {
+		return nil, fmt.Errorf("persist: open snapshot: %w", err)
+	}
+	if err := snapDB.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(snapshotBucket)
+		return err
+	}); err != nil {
+		snapDB.Close()
+		return nil, fmt.Errorf("persist: init snapshot bucket: %w", err)
+	}
+
+	aolDB, err := bolt.Open(aolPath(cfg.Dir), 0o600, opts)
+	if err != nil {
+		snapDB.Close()
+		return nil, fmt.Errorf("persist: open aol: %w", err)
+	}
+	if err := aolDB.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(aolBucket)
+		return err
+	}); err != nil {
+		snapDB.Close()
+		aolDB.Close()
+		return nil, fmt.Errorf("persist: init aol bucket: %w", err)
+	}
+
+	s := &Store{cfg: cfg, snapDB: snapDB, aolDB: aolDB}
+	if err := s.measureAOL(); err != nil {
+		snapDB.Close()
+		aolDB.Close()
+		return nil, err
+	}
+
+	if cfg.Fsync == FsyncEverySec {
+		s.stopFsync = make(chan struct{})
+		go s.runFsyncEverySec()
+	}
+	return s, nil
+}
+
+// Close releases the underlying bbolt files.
+func (s *Store) Close() error {
+	if s.stopFsync != nil {
+		close(s.stopFsync)
+	}
+	err1 := s.snapDB.Close()
+	err2 := s.aolDB.Close()
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+// runFsyncEverySec flushes both bbolt files to disk roughly once a
+// second, bounding the loss window for FsyncEverySec the same way
+// Redis's AOF "everysec" policy does, instead of relying solely on the
+// OS's own writeback like FsyncNever. It reads s.snapDB under a read
+// lock since Snapshot swaps that pointer out from under a running
+// snapshot.
+func (s *Store) runFsyncEverySec() {
+	ticker := time.NewTicker(fsyncEverySecInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopFsync:
+			return
+		case <-ticker.C:
+			s.mu.RLock()
+			snapDB := s.snapDB
+			s.mu.RUnlock()
+			snapDB.Sync()
+			s.aolDB.Sync()
+		}
+	}
+}
+
+func (s *Store) measureAOL() error {
+	return s.aolDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(aolBucket)
+		var total int64
+		if err := b.ForEach(func(_, v []byte) error {
+			total += int64(len(v))
+			return nil
+		}); err != nil {
+			return err
+		}
+		atomic.StoreInt64(&s.aolBytes, total)
+		return nil
+	})
+}
+
+// AppendPut durably records key/value/expireAtUnixNano (0 for no TTL) in
+// the append-only log. Callers should do this before acknowledging the
+// write to the client. Writes are batched with bolt.Batch so many
+// concurrent PUTs share one transaction (and, under FsyncAlways, one
+// fsync).
+func (s *Store) AppendPut(key, value string, expireAtUnixNano int64) error {
+	rec := encodeRecord(key, value, expireAtUnixNano)
+	err := s.aolDB.Batch(func(tx *bolt.Tx) error {
+		b := tx.Bucket(aolBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		var seqKey [8]byte
+		binary.BigEndian.PutUint64(seqKey[:], seq)
+		return b.Put(seqKey[:], rec)
+	})
+	if err != nil {
+		return fmt.Errorf("persist: append aol record: %w", err)
+	}
+	atomic.AddInt64(&s.aolBytes, int64(len(rec)))
+	return nil
+}
+
+// AppendDel durably records a tombstone for key in the append-only log,
+// so a Replay after this key was deleted doesn't resurrect whatever
+// value the last snapshot (or an earlier AOL record) held for it.
+// Callers should do this before acknowledging the deletion to the
+// client, the same as AppendPut for a write.
+func (s *Store) AppendDel(key string) error {
+	rec := encodeDeleteRecord(key)
+	err := s.aolDB.Batch(func(tx *bolt.Tx) error {
+		b := tx.Bucket(aolBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		var seqKey [8]byte
+		binary.BigEndian.PutUint64(seqKey[:], seq)
+		return b.Put(seqKey[:], rec)
+	})
+	if err != nil {
+		return fmt.Errorf("persist: append aol tombstone: %w", err)
+	}
+	atomic.AddInt64(&s.aolBytes, int64(len(rec)))
+	return nil
+}
+
+// Replay calls apply(key, value, expireAtUnixNano, deleted) once for
+// every key in the latest snapshot, then once for every AOL record
+// logged since, in log order — enough for a caller to rebuild the state
+// a cache held before restart. expireAtUnixNano is 0 for a key with no
+// TTL. deleted is true for an AOL tombstone (value and expireAtUnixNano
+// are both zero in that case); a snapshot never contains tombstones
+// since it's always a live-keys-only rebuild, so deleted is always false
+// while replaying it. Because AOL records are replayed in log order, a
+// tombstone for a key correctly overrides any put (from the snapshot or
+// an earlier AOL record) the caller already applied for it.
+func (s *Store) Replay(apply func(key, value string, expireAtUnixNano int64, deleted bool)) error {
+	if err := s.snapDB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(snapshotBucket).ForEach(func(k, v []byte) error {
+			value, expireAtUnixNano, err := decodeSnapshotValue(v)
+			if err != nil {
+				return err
+			}
+			apply(string(k), value, expireAtUnixNano, false)
+			return nil
+		})
+	}); err != nil {
+		return fmt.Errorf("persist: replay snapshot: %w", err)
+	}
+
+	if err := s.aolDB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(aolBucket).ForEach(func(_, v []byte) error {
+			kind, key, value, expireAtUnixNano, err := decodeRecord(v)
+			if err != nil {
+				return err
+			}
+			apply(key, value, expireAtUnixNano, kind == recordDel)
+			return nil
+		})
+	}); err != nil {
+		return fmt.Errorf("persist: replay aol: %w", err)
+	}
+	return nil
+}
+
+// Snapshot atomically replaces the on-disk snapshot with the
+// key/value/expireAtUnixNano triples iterate produces (a walk over every
+// shard, taken under that shard's own read lock), then truncates the
+// AOL, since every mutation it held is now captured in the new
+// snapshot.
+func (s *Store) Snapshot(iterate func(yield func(key, value string, expireAtUnixNano int64))) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmpPath := snapshotPath(s.cfg.Dir) + ".tmp"
+	os.Remove(tmpPath)
+	tmpDB, err := bolt.Open(tmpPath, 0o600, &bolt.Options{NoSync: s.cfg.Fsync != FsyncAlways})
+	if err != nil {
+		return fmt.Errorf("persist: open tmp snapshot: %w", err)
+	}
+
+	writeErr := tmpDB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(snapshotBucket)
+		if err != nil {
+			return err
+		}
+		var putErr error
+		iterate(func(key, value string, expireAtUnixNano int64) {
+			if putErr != nil {
+				return
+			}
+			putErr = b.Put([]byte(key), encodeSnapshotValue(value, expireAtUnixNano))
+		})
+		return putErr
+	})
+	tmpDB.Close()
+	if writeErr != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("persist: write tmp snapshot: %w", writeErr)
+	}
+
+	if err := s.snapDB.Close(); err != nil {
+		return fmt.Errorf("persist: close snapshot before swap: %w", err)
+	}
+	if err := os.Rename(tmpPath, snapshotPath(s.cfg.Dir)); err != nil {
+		return fmt.Errorf("persist: swap snapshot file: %w", err)
+	}
+	s.snapDB, err = bolt.Open(snapshotPath(s.cfg.Dir), 0o600, &bolt.Options{NoSync: s.cfg.Fsync != FsyncAlways})
+	if err != nil {
+		return fmt.Errorf("persist: reopen snapshot: %w", err)
+	}
+
+	return s.truncateAOL()
+}
+
+// truncateAOL drops every record in the AOL bucket, called once its
+// contents are captured in a fresh snapshot.
+func (s *Store) truncateAOL() error {
+	if err := s.aolDB.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(aolBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(aolBucket)
+		return err
+	}); err != nil {
+		return fmt.Errorf("persist: truncate aol: %w", err)
+	}
+	atomic.StoreInt64(&s.aolBytes, 0)
+	return nil
+}
+
+// ShouldCompact reports whether the AOL has grown past
+// cfg.CompactThreshold and a fresh snapshot should be taken to rewrite
+// it.
+func (s *Store) ShouldCompact() bool {
+	if s.cfg.CompactThreshold <= 0 {
+		return false
+	}
+	return atomic.LoadInt64(&s.aolBytes) >= s.cfg.CompactThreshold
+}
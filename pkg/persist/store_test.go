@@ -0,0 +1,307 @@
+package persist
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendPutThenReplay(t *testing.T) {
+	s, err := Open(Config{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.AppendPut("k1", "v1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AppendPut("k2", "v2", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	if err := s.Replay(func(key, value string, expireAtUnixNano int64, deleted bool) { got[key] = value }); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"k1": "v1", "k2": "v2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Replay = %v, want %v", got, want)
+	}
+}
+
+// TestAppendPutThenReplayPreservesTTL checks that a key's expiry survives
+// the AOL round trip, not just its value.
+func TestAppendPutThenReplayPreservesTTL(t *testing.T) {
+	s, err := Open(Config{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	expireAt := time.Now().Add(time.Hour).UnixNano()
+	if err := s.AppendPut("k1", "v1", expireAt); err != nil {
+		t.Fatal(err)
+	}
+
+	var gotExpireAt int64
+	found := false
+	if err := s.Replay(func(key, value string, expireAtUnixNano int64, deleted bool) {
+		if key == "k1" {
+			gotExpireAt = expireAtUnixNano
+			found = true
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Fatal("k1 not replayed")
+	}
+	if gotExpireAt != expireAt {
+		t.Fatalf("replayed expireAtUnixNano = %d, want %d", gotExpireAt, expireAt)
+	}
+}
+
+func TestSnapshotTruncatesAOLAndSurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(Config{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AppendPut("k1", "v1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Snapshot(func(yield func(key, value string, expireAtUnixNano int64)) { yield("k1", "v1", 0) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s2, err := Open(Config{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Close()
+
+	got := map[string]string{}
+	replayed := 0
+	if err := s2.Replay(func(key, value string, expireAtUnixNano int64, deleted bool) {
+		got[key] = value
+		replayed++
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"k1": "v1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Replay after reopen = %v, want %v", got, want)
+	}
+	if replayed != 1 {
+		t.Fatalf("Replay after reopen applied %d records, want 1 (snapshot only, AOL truncated)", replayed)
+	}
+}
+
+// TestReplayCombinesSnapshotAndAOLTail checks that a key captured in the
+// snapshot and a key only ever appended to the AOL since both come back
+// out of Replay.
+func TestReplayCombinesSnapshotAndAOLTail(t *testing.T) {
+	s, err := Open(Config{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.AppendPut("k1", "v1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Snapshot(func(yield func(key, value string, expireAtUnixNano int64)) {
+		yield("k1", "v1", 0)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AppendPut("k2", "v2", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	if err := s.Replay(func(key, value string, expireAtUnixNano int64, deleted bool) { got[key] = value }); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"k1": "v1", "k2": "v2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Replay = %v, want %v", got, want)
+	}
+}
+
+// TestAppendDelThenReplaySkipsKey checks that a tombstone logged after a
+// put is replayed after it and reported as deleted, so a caller applying
+// records in order ends up without the key.
+func TestAppendDelThenReplaySkipsKey(t *testing.T) {
+	s, err := Open(Config{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.AppendPut("k1", "v1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AppendPut("k2", "v2", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AppendDel("k1"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	deletedKeys := map[string]bool{}
+	if err := s.Replay(func(key, value string, expireAtUnixNano int64, deleted bool) {
+		if deleted {
+			deletedKeys[key] = true
+			delete(got, key)
+			return
+		}
+		got[key] = value
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !deletedKeys["k1"] {
+		t.Fatal("Replay did not report k1's tombstone as deleted")
+	}
+	want := map[string]string{"k2": "v2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Replay = %v, want %v", got, want)
+	}
+}
+
+// TestShouldCompactTracksAOLSizeAcrossSnapshots checks that ShouldCompact
+// flips on once the AOL grows past CompactThreshold and flips back off
+// once a snapshot truncates it.
+func TestShouldCompactTracksAOLSizeAcrossSnapshots(t *testing.T) {
+	s, err := Open(Config{Dir: t.TempDir(), CompactThreshold: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if s.ShouldCompact() {
+		t.Fatal("ShouldCompact is true on a freshly opened store")
+	}
+	if err := s.AppendPut("k1", strings.Repeat("v", 32), 0); err != nil {
+		t.Fatal(err)
+	}
+	if !s.ShouldCompact() {
+		t.Fatal("ShouldCompact did not flip on once the AOL exceeded CompactThreshold")
+	}
+
+	if err := s.Snapshot(func(yield func(key, value string, expireAtUnixNano int64)) {
+		yield("k1", strings.Repeat("v", 32), 0)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if s.ShouldCompact() {
+		t.Fatal("ShouldCompact still true after a snapshot truncated the AOL")
+	}
+}
+
+// TestCrashRecoveryReplaysUnsnapshottedAOLTail checks the actual guarantee
+// a crash relies on: a write that only ever made it into the AOL (never
+// into a snapshot) before the process stopped is still there, combined
+// correctly with the last snapshot, once a new Store opens the same
+// directory.
+func TestCrashRecoveryReplaysUnsnapshottedAOLTail(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(Config{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AppendPut("k1", "v1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Snapshot(func(yield func(key, value string, expireAtUnixNano int64)) {
+		yield("k1", "v1", 0)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AppendPut("k2", "v2", 0); err != nil {
+		t.Fatal(err)
+	}
+	// Close (not Snapshot) here: k2 leaves the AOL un-truncated, the same
+	// state on disk a crash right after this AppendPut would leave behind.
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s2, err := Open(Config{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Close()
+
+	got := map[string]string{}
+	if err := s2.Replay(func(key, value string, expireAtUnixNano int64, deleted bool) { got[key] = value }); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"k1": "v1", "k2": "v2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Replay after restart = %v, want %v", got, want)
+	}
+}
+
+// TestFsyncEverySecStartsAndStops checks that configuring FsyncEverySec
+// starts the periodic fsync loop without it blocking normal use, and
+// that Close succeeds while the loop is running.
+func TestFsyncEverySecStartsAndStops(t *testing.T) {
+	s, err := Open(Config{Dir: t.TempDir(), Fsync: FsyncEverySec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.stopFsync == nil {
+		t.Fatal("Open with FsyncEverySec did not start the fsync loop")
+	}
+	if err := s.AppendPut("k1", "v1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestWriteLockExcludesSnapshot checks the mechanism a caller relies on
+// to keep an AOL append and its in-memory apply atomic with respect to
+// a concurrent snapshot: while a write holds WriteLock, Snapshot must
+// block until WriteUnlock, never interleave.
+func TestWriteLockExcludesSnapshot(t *testing.T) {
+	s, err := Open(Config{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	s.WriteLock()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Snapshot(func(yield func(key, value string, expireAtUnixNano int64)) {})
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Snapshot completed while a WriteLock was held")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	s.WriteUnlock()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Snapshot did not complete after WriteUnlock")
+	}
+}
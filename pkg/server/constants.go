@@ -10,9 +10,3 @@ const (
 	TCPKeepAliveInterval    = 30 * time.Second
 	MaxCacheEntriesPerShard = 20_000
 )
-
-var (
-	ErrorResponse    = []byte("ERROR\n")
-	OkResponse       = []byte("OK\n")
-	NotFoundResponse = []byte("NOTFOUND\n")
-)
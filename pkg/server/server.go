@@ -6,24 +6,30 @@ import (
 	"io"
 	"log"
 	"net"
-	"sync"
+	"strconv"
+	"strings"
 	"time"
 
+	"server/internal/proto"
 	"server/pkg/cache"
 )
 
-// reduce gc pressure
-var bufferPool = sync.Pool{
-	New: func() interface{} {
-		return make([]byte, ReadBufferSize)
-	},
+type Server struct {
+	cache cache.Cache
 }
 
-type Server struct {
-	cache *cache.ShardedCache
+// rebalancer is implemented by cache.Cache values that can have their
+// cluster membership changed at runtime — currently only
+// *cluster.ClusterCache — so CLUSTER REBALANCE can type-assert onto it
+// without pkg/server importing pkg/cluster.
+type rebalancer interface {
+	Rebalance(nodes []string)
 }
 
-func NewServer(cache *cache.ShardedCache) *Server {
+// NewServer builds a Server dispatching onto cache, which may be a
+// single-node *cache.ShardedCache or a *cluster.ClusterCache fronting
+// several nodes.
+func NewServer(cache cache.Cache) *Server {
 	return &Server{
 		cache: cache,
 	}
@@ -70,12 +76,18 @@ func (s *Server) Start() error {
 	}
 }
 
+// handleConnection decodes RESP commands off conn through a proto.Reader
+// and answers through a proto.Writer, flushing only once the reader has
+// drained everything already buffered from the client. That lets a
+// client pipeline several commands in one write and get all the replies
+// back in one read, instead of paying a syscall round-trip per command.
 func (s *Server) handleConnection(conn net.Conn) {
 	defer conn.Close()
-	reader := bufio.NewReaderSize(conn, ReadBufferSize)
+	rd := proto.NewReader(bufio.NewReaderSize(conn, ReadBufferSize))
+	wr := proto.NewWriter(bufio.NewWriterSize(conn, ReadBufferSize))
 
 	for {
-		line, err := reader.ReadString('\n')
+		name, args, err := readCommand(rd)
 		if err != nil {
 			if err != io.EOF {
 				log.Println("Error reading:", err)
@@ -83,71 +95,254 @@ func (s *Server) handleConnection(conn net.Conn) {
 			return
 		}
 
-		ln := line
-		if len(ln) < 4 {
-			conn.Write(ErrorResponse)
-			continue
-		}
+		s.dispatch(wr, name, args)
 
-		switch ln[:3] {
-		case "GET":
-			s.handleGet(conn, ln)
-		case "PUT":
-			s.handlePut(conn, ln)
-		default:
-			conn.Write(ErrorResponse)
+		if rd.Buffered() == 0 {
+			if err := wr.Flush(); err != nil {
+				return
+			}
 		}
 	}
 }
 
-func (s *Server) handleGet(conn net.Conn, ln string) {
-	space := 3
-	for space < len(ln) && ln[space] == ' ' {
-		space++
+// readCommand reads one command through ReadReply, which accepts both
+// RESP array-of-bulk-strings framing and bare inline lines, and returns
+// its uppercased name plus remaining arguments.
+func readCommand(rd *proto.Reader) (name string, args []string, err error) {
+	reply, err := rd.ReadReply()
+	if err != nil {
+		return "", nil, err
+	}
+
+	var fields []string
+	switch v := reply.(type) {
+	case []string:
+		fields = v
+	case []interface{}:
+		fields = make([]string, len(v))
+		for i, elem := range v {
+			s, ok := elem.(string)
+			if !ok {
+				return "", nil, fmt.Errorf("proto: command argument %d is not a bulk string", i)
+			}
+			fields[i] = s
+		}
+	default:
+		return "", nil, fmt.Errorf("proto: unexpected command framing %T", reply)
 	}
 
-	key := ln[space : len(ln)-1]
-	val, found := s.cache.Get(key)
+	if len(fields) == 0 {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	return strings.ToUpper(fields[0]), fields[1:], nil
+}
 
-	if found {
-		buf := bufferPool.Get().([]byte)
-		n := copy(buf, val)
-		buf[n] = '\n'
-		conn.Write(buf[:n+1])
-		bufferPool.Put(buf)
+func (s *Server) dispatch(wr *proto.Writer, name string, args []string) {
+	switch name {
+	case "GET":
+		s.handleGet(wr, args)
+	case "PUT", "SET":
+		s.handlePut(wr, args)
+	case "MGET":
+		s.handleMGet(wr, args)
+	case "MSET":
+		s.handleMSet(wr, args)
+	case "DEL":
+		s.handleDel(wr, args)
+	case "EXISTS":
+		s.handleExists(wr, args)
+	case "EXPIRE":
+		s.handleExpire(wr, args)
+	case "TTL":
+		s.handleTTL(wr, args)
+	case "PING":
+		s.handlePing(wr, args)
+	case "ECHO":
+		s.handleEcho(wr, args)
+	case "COMMAND":
+		s.handleCommand(wr, args)
+	case "CLUSTER":
+		s.handleCluster(wr, args)
+	default:
+		wr.WriteError("ERR unknown command '" + name + "'")
+	}
+}
+
+func (s *Server) handleGet(wr *proto.Writer, args []string) {
+	if len(args) != 1 {
+		wr.WriteError("ERR wrong number of arguments for GET")
+		return
+	}
+	if val, found := s.cache.Get(args[0]); found {
+		wr.WriteBulkString(val)
 	} else {
-		conn.Write(NotFoundResponse)
+		wr.WriteNil()
 	}
 }
 
-func (s *Server) handlePut(conn net.Conn, ln string) {
-	space := 3
-	for space < len(ln) && ln[space] == ' ' {
-		space++
+func (s *Server) handlePut(wr *proto.Writer, args []string) {
+	if len(args) != 2 {
+		wr.WriteError("ERR wrong number of arguments for PUT")
+		return
 	}
+	key, value := args[0], args[1]
+	if len(key) > MaxKeyValueSize || len(value) > MaxKeyValueSize {
+		wr.WriteError("ERR key or value too long")
+		return
+	}
+	if err := s.cache.Put(key, value); err != nil {
+		wr.WriteError("ERR " + err.Error())
+		return
+	}
+	wr.WriteStatus("OK")
+}
 
-	rest := ln[space : len(ln)-1]
-	idx := -1
-	for i := 0; i < len(rest); i++ {
-		if rest[i] == ' ' {
-			idx = i
-			break
+func (s *Server) handleMGet(wr *proto.Writer, args []string) {
+	if len(args) < 1 {
+		wr.WriteError("ERR wrong number of arguments for MGET")
+		return
+	}
+	results := s.cache.MGet(args)
+	wr.WriteArrayHeader(len(results))
+	for _, r := range results {
+		if r.Found {
+			wr.WriteBulkString(r.Value)
+		} else {
+			wr.WriteNil()
 		}
 	}
+}
 
-	if idx < 1 {
-		conn.Write(ErrorResponse)
+func (s *Server) handleMSet(wr *proto.Writer, args []string) {
+	if len(args) == 0 || len(args)%2 != 0 {
+		wr.WriteError("ERR wrong number of arguments for MSET")
 		return
 	}
+	pairs := make([]cache.KV, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		key, value := args[i], args[i+1]
+		if len(key) > MaxKeyValueSize || len(value) > MaxKeyValueSize {
+			wr.WriteError("ERR key or value too long")
+			return
+		}
+		pairs = append(pairs, cache.KV{Key: key, Value: value})
+	}
+	if err := s.cache.MSet(pairs); err != nil {
+		wr.WriteError("ERR " + err.Error())
+		return
+	}
+	wr.WriteStatus("OK")
+}
 
-	key := rest[:idx]
-	value := rest[idx+1:]
+func (s *Server) handleDel(wr *proto.Writer, args []string) {
+	if len(args) < 1 {
+		wr.WriteError("ERR wrong number of arguments for DEL")
+		return
+	}
+	wr.WriteInt(int64(s.cache.DelMany(args)))
+}
 
-	if len(key) > MaxKeyValueSize || len(value) > MaxKeyValueSize {
-		conn.Write(ErrorResponse)
+func (s *Server) handleExists(wr *proto.Writer, args []string) {
+	if len(args) < 1 {
+		wr.WriteError("ERR wrong number of arguments for EXISTS")
+		return
+	}
+	wr.WriteInt(int64(s.cache.ExistsMany(args)))
+}
+
+func (s *Server) handleExpire(wr *proto.Writer, args []string) {
+	if len(args) != 2 {
+		wr.WriteError("ERR wrong number of arguments for EXPIRE")
+		return
+	}
+	seconds, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		wr.WriteError("ERR value is not an integer or out of range")
 		return
 	}
+	if s.cache.Expire(args[0], time.Duration(seconds)*time.Second) {
+		wr.WriteInt(1)
+	} else {
+		wr.WriteInt(0)
+	}
+}
+
+// handleTTL mirrors Redis's TTL semantics: -2 means the key does not
+// exist (or has already expired), -1 means it exists with no expiry,
+// and otherwise the remaining time-to-live is reported in whole seconds.
+func (s *Server) handleTTL(wr *proto.Writer, args []string) {
+	if len(args) != 1 {
+		wr.WriteError("ERR wrong number of arguments for TTL")
+		return
+	}
+	remaining, hasExpiry, exists := s.cache.TTL(args[0])
+	switch {
+	case !exists:
+		wr.WriteInt(-2)
+	case !hasExpiry:
+		wr.WriteInt(-1)
+	default:
+		wr.WriteInt(int64(remaining / time.Second))
+	}
+}
+
+func (s *Server) handlePing(wr *proto.Writer, args []string) {
+	switch len(args) {
+	case 0:
+		wr.WriteStatus("PONG")
+	case 1:
+		wr.WriteBulkString(args[0])
+	default:
+		wr.WriteError("ERR wrong number of arguments for PING")
+	}
+}
+
+func (s *Server) handleEcho(wr *proto.Writer, args []string) {
+	if len(args) != 1 {
+		wr.WriteError("ERR wrong number of arguments for ECHO")
+		return
+	}
+	wr.WriteBulkString(args[0])
+}
+
+// handleCluster dispatches CLUSTER's subcommands. The only one today is
+// REBALANCE, an operator-triggered way to add or remove cluster nodes at
+// runtime without restarting any process.
+func (s *Server) handleCluster(wr *proto.Writer, args []string) {
+	if len(args) < 1 {
+		wr.WriteError("ERR wrong number of arguments for CLUSTER")
+		return
+	}
+	switch strings.ToUpper(args[0]) {
+	case "REBALANCE":
+		s.handleClusterRebalance(wr, args[1:])
+	default:
+		wr.WriteError("ERR unknown CLUSTER subcommand '" + args[0] + "'")
+	}
+}
+
+// handleClusterRebalance replaces cluster membership with exactly nodes
+// (CLUSTER REBALANCE host:port [host:port ...]), the same semantics as
+// cluster.Ring.Rebalance: addresses missing from the ring are added,
+// addresses no longer listed are removed. It errors out on a single-node
+// cache, since there's no ring to rebalance.
+func (s *Server) handleClusterRebalance(wr *proto.Writer, nodes []string) {
+	if len(nodes) < 1 {
+		wr.WriteError("ERR wrong number of arguments for CLUSTER REBALANCE")
+		return
+	}
+	rb, ok := s.cache.(rebalancer)
+	if !ok {
+		wr.WriteError("ERR CLUSTER REBALANCE requires cluster mode")
+		return
+	}
+	rb.Rebalance(nodes)
+	wr.WriteStatus("OK")
+}
 
-	s.cache.Put(key, value)
-	conn.Write(OkResponse)
+// handleCommand answers just enough of COMMAND to satisfy clients that
+// probe it on connect (e.g. COMMAND DOCS); this server doesn't maintain
+// a real command table, so every form replies with an empty array.
+func (s *Server) handleCommand(wr *proto.Writer, args []string) {
+	wr.WriteArrayHeader(0)
 }
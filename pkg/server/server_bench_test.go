@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"testing"
+
+	"server/internal/proto"
+	"server/pkg/cache"
+)
+
+// startBenchServer spins up a Server on a loopback TCP listener and
+// returns a client connection to it, so the benchmarks below measure the
+// real handleConnection path. A real socket (unlike net.Pipe) lets the
+// kernel coalesce several pipelined writes into one readable chunk,
+// which is what actually lets handleConnection batch a flush.
+func startBenchServer(b *testing.B) net.Conn {
+	b.Helper()
+	c, err := cache.NewShardedCache(1024, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	s := NewServer(c)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		s.handleConnection(conn)
+	}()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		client.Close()
+		ln.Close()
+	})
+	return client
+}
+
+func sendPut(wr *proto.Writer, key, value string) {
+	wr.WriteArrayHeader(3)
+	wr.WriteArg("PUT")
+	wr.WriteArg(key)
+	wr.WriteArg(value)
+}
+
+// BenchmarkSingleCommandPerFlush issues one PUT, flushes, and waits for
+// the reply before sending the next — the round-trip pattern the old
+// newline protocol forced on every caller.
+func BenchmarkSingleCommandPerFlush(b *testing.B) {
+	client := startBenchServer(b)
+	cwr := proto.NewWriter(client)
+	crd := proto.NewReader(bufio.NewReader(client))
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		sendPut(cwr, "k"+strconv.Itoa(i%64), "v")
+		cwr.Flush()
+		if _, err := crd.ReadReply(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkPipelinedBatch writes a batch of commands in one flush and
+// reads all the replies back, the pattern a pipelining-aware client uses
+// against the proto.Reader/Writer-based handleConnection.
+func BenchmarkPipelinedBatch(b *testing.B) {
+	const batch = 32
+	client := startBenchServer(b)
+	cwr := proto.NewWriter(client)
+	crd := proto.NewReader(bufio.NewReader(client))
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i += batch {
+		n := batch
+		if i+n > b.N {
+			n = b.N - i
+		}
+		for j := 0; j < n; j++ {
+			sendPut(cwr, "k"+strconv.Itoa((i+j)%64), "v")
+		}
+		cwr.Flush()
+		for j := 0; j < n; j++ {
+			if _, err := crd.ReadReply(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
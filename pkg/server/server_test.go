@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+
+	"server/internal/proto"
+	"server/pkg/cache"
+	"server/pkg/cluster"
+)
+
+// startTestServer is startBenchServer's *testing.T counterpart, used by
+// correctness tests that don't need a *testing.B.
+func startTestServer(t *testing.T) net.Conn {
+	t.Helper()
+	c, err := cache.NewShardedCache(64, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return startTestServerWithCache(t, c)
+}
+
+// startTestServerWithCache is startTestServer for a caller that needs
+// control over the underlying cache.Cache, e.g. a *cluster.ClusterCache
+// instead of a bare *cache.ShardedCache.
+func startTestServerWithCache(t *testing.T, c cache.Cache) net.Conn {
+	t.Helper()
+	s := NewServer(c)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		s.handleConnection(conn)
+	}()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		ln.Close()
+	})
+	return client
+}
+
+// TestInlineCommand exercises the bare-inline-line path (no RESP array
+// framing) that readCommand accepts via proto.Reader.ReadReply.
+func TestInlineCommand(t *testing.T) {
+	client := startTestServer(t)
+	rd := proto.NewReader(bufio.NewReader(client))
+
+	if _, err := client.Write([]byte("PUT foo bar\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := rd.ReadReply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "OK" {
+		t.Fatalf("PUT foo bar: got %#v, want OK", reply)
+	}
+
+	if _, err := client.Write([]byte("GET foo\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	reply, err = rd.ReadReply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "bar" {
+		t.Fatalf("GET foo: got %#v, want bar", reply)
+	}
+}
+
+// TestClusterRebalanceRequiresClusterMode checks that CLUSTER REBALANCE
+// errors out on a single-node cache instead of silently doing nothing,
+// since there's no ring for it to act on.
+func TestClusterRebalanceRequiresClusterMode(t *testing.T) {
+	client := startTestServer(t)
+	rd := proto.NewReader(bufio.NewReader(client))
+
+	if _, err := client.Write([]byte("CLUSTER REBALANCE 127.0.0.1:1\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := rd.ReadReply()
+	if err == nil {
+		t.Fatalf("CLUSTER REBALANCE on a single-node cache = %#v, want an error", reply)
+	}
+	if !strings.Contains(err.Error(), "cluster mode") {
+		t.Fatalf("CLUSTER REBALANCE error = %v, want it to mention cluster mode", err)
+	}
+}
+
+// TestClusterRebalanceCommandChangesOwnership checks that an operator
+// can actually reach ClusterCache.Rebalance at runtime through the RESP
+// protocol: CLUSTER REBALANCE on a cluster-mode server changes which
+// node a key routes to without restarting anything.
+func TestClusterRebalanceCommandChangesOwnership(t *testing.T) {
+	local, err := cache.NewShardedCache(64, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	self := "127.0.0.1:1"
+	peer := "127.0.0.1:2"
+	cc := cluster.NewClusterCache(self, []string{self, peer}, local)
+
+	client := startTestServerWithCache(t, cc)
+	wr := proto.NewWriter(client)
+	rd := proto.NewReader(bufio.NewReader(client))
+
+	wr.WriteArrayHeader(2)
+	wr.WriteArg("CLUSTER")
+	wr.WriteArg("REBALANCE")
+	if err := wr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rd.ReadReply(); err == nil {
+		t.Fatal("CLUSTER REBALANCE with no node list should have errored")
+	}
+
+	wr.WriteArrayHeader(3)
+	wr.WriteArg("CLUSTER")
+	wr.WriteArg("REBALANCE")
+	wr.WriteArg(self)
+	if err := wr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := rd.ReadReply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "OK" {
+		t.Fatalf("CLUSTER REBALANCE %s: got %#v, want OK", self, reply)
+	}
+
+	// With self as the only node left, every key must now be owned and
+	// stored locally instead of proxied to the (unreachable) peer.
+	for i := 0; i < 16; i++ {
+		key := "key-" + string(rune('a'+i))
+		wr.WriteArrayHeader(3)
+		wr.WriteArg("PUT")
+		wr.WriteArg(key)
+		wr.WriteArg("v")
+		if err := wr.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if reply, err := rd.ReadReply(); err != nil || reply != "OK" {
+			t.Fatalf("PUT %s after CLUSTER REBALANCE: got %#v, %v, want OK", key, reply, err)
+		}
+		if _, found := local.Get(key); !found {
+			t.Fatalf("%s not stored locally after CLUSTER REBALANCE to %s only", key, self)
+		}
+	}
+}